@@ -19,8 +19,9 @@ func NewCgroupManager(path string) *CgroupManager {
 }
 
 // 将进程pid加入到这个cgroup中
+//未配置的子系统也会加入，之后才能通过 rocker update 增加限制
 func (c *CgroupManager) Apply(pid int, res *subsystem.ResourceConfig) error {
-	for _, subSysIns := range subsystem.SubsystemIns {
+	for _, subSysIns := range subsystem.GetSubsystemIns() {
 		err := subSysIns.Apply(c.Path, pid, res)
 		if err != nil {
 			return err
@@ -31,7 +32,7 @@ func (c *CgroupManager) Apply(pid int, res *subsystem.ResourceConfig) error {
 
 // 设置cgroup资源限制
 func (c *CgroupManager) Set(res *subsystem.ResourceConfig) error {
-	for _, subSysIns := range subsystem.SubsystemIns {
+	for _, subSysIns := range subsystem.GetSubsystemIns() {
 		err := subSysIns.Set(c.Path, res)
 		if err != nil {
 			return err
@@ -42,10 +43,42 @@ func (c *CgroupManager) Set(res *subsystem.ResourceConfig) error {
 
 //释放cgroup
 func (c *CgroupManager) Destroy() error {
-	for _, subSysIns := range subsystem.SubsystemIns {
+	for _, subSysIns := range subsystem.GetSubsystemIns() {
+		//已经释放
+		if !subsystem.CgroupExists(subSysIns.Name(), c.Path) {
+			continue
+		}
 		if err := subSysIns.Remove(c.Path); err != nil {
 			log.Warnf("remove cgroup fail %v", err)
 		}
 	}
 	return nil
 }
+
+//冻结 cgroup 中的所有进程
+func (c *CgroupManager) Freeze() error {
+	return subsystem.SetFreezerState(c.Path, subsystem.Frozen)
+}
+
+//恢复 cgroup 中被冻结的进程
+func (c *CgroupManager) Thaw() error {
+	return subsystem.SetFreezerState(c.Path, subsystem.Thawed)
+}
+
+//获取 cgroup 中的所有进程
+func (c *CgroupManager) GetPids() ([]int, error) {
+	return subsystem.GetCgroupPids(c.Path)
+}
+
+//获取 cgroup 资源使用统计
+func (c *CgroupManager) GetStats() (*subsystem.Stats, error) {
+	stats := &subsystem.Stats{}
+	for _, subSysIns := range subsystem.GetSubsystemIns() {
+		if statsIns, ok := subSysIns.(subsystem.StatsSubsystem); ok {
+			if err := statsIns.GetStats(c.Path, stats); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return stats, nil
+}
@@ -0,0 +1,197 @@
+package subsystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"github.com/RedDragonet/rocker/pkg/units"
+	"golang.org/x/sys/unix"
+)
+
+type BlkioSubSystem struct {
+}
+
+//块设备的读写限制 major:minor rate
+type ThrottleDevice struct {
+	Major uint32
+	Minor uint32
+	Rate  uint64
+}
+
+func (t *ThrottleDevice) String() string {
+	return fmt.Sprintf("%d:%d %d", t.Major, t.Minor, t.Rate)
+}
+
+func (c *BlkioSubSystem) Name() string {
+	return "blkio"
+}
+
+func (c *BlkioSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	log.Infof("设置 cgroup blkio 开始")
+	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, true); err == nil {
+		if !blkioConfigured(res) {
+			log.Debugf("未配置 cgroup blkio 跳过")
+			return nil
+		}
+
+		if res.BlkioWeight != "" {
+			//未开启 CFQ 调度时不存在 blkio.weight，使用 BFQ 的 blkio.bfq.weight
+			weightFile := "blkio.weight"
+			if _, err := os.Stat(path.Join(cgroupAbsolutePath, weightFile)); os.IsNotExist(err) {
+				weightFile = "blkio.bfq.weight"
+			}
+			if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, weightFile), []byte(res.BlkioWeight), 0644); err != nil {
+				log.Errorf("设置 cgroup blkio weight 失败 %v", err)
+				return fmt.Errorf("设置 cgroup blkio weight 失败 %v", err)
+			}
+		}
+
+		throttles := []struct {
+			file    string
+			devices []string
+			bytes   bool
+		}{
+			{"blkio.throttle.read_bps_device", res.DeviceReadBps, true},
+			{"blkio.throttle.write_bps_device", res.DeviceWriteBps, true},
+			{"blkio.throttle.read_iops_device", res.DeviceReadIOps, false},
+			{"blkio.throttle.write_iops_device", res.DeviceWriteIOps, false},
+		}
+		for _, throttle := range throttles {
+			devices, err := ParseThrottleDevices(throttle.devices, throttle.bytes)
+			if err != nil {
+				log.Errorf("设置 cgroup blkio 失败 %v", err)
+				return err
+			}
+			//每次只能写入一个设备
+			for _, device := range devices {
+				if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, throttle.file), []byte(device.String()), 0644); err != nil {
+					log.Errorf("设置 cgroup blkio %s %s 失败 %v", throttle.file, device.String(), err)
+					return fmt.Errorf("设置 cgroup blkio %s %s 失败 %v", throttle.file, device.String(), err)
+				}
+			}
+		}
+		log.Debugf("设置 cgroup blkio 成功")
+		return nil
+	} else {
+		log.Errorf("设置 cgroup blkio 失败 %v", err)
+		return err
+	}
+}
+
+func (c *BlkioSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	log.Infof("写入 cgroup blkio pid=%d 开始", pid)
+	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			log.Errorf("写入 cgroup blkio pid=%d 失败 %v", pid, err)
+			return fmt.Errorf("写入 cgroup blkio pid=%d 失败 %v", pid, err)
+		} else {
+			log.Debugf("写入 cgroup blkio pid=%d 成功", pid)
+			return nil
+		}
+	} else {
+		log.Errorf("写入 cgroup blkio pid=%d 失败 %v", pid, err)
+		return err
+	}
+}
+
+func (c *BlkioSubSystem) Remove(cgroupPath string) error {
+	log.Infof("删除 cgroup blkio 开始")
+	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(cgroupAbsolutePath)
+	} else {
+		log.Errorf("删除 cgroup blkio 失败 %v", err)
+		return err
+	}
+}
+
+func blkioConfigured(res *ResourceConfig) bool {
+	return res.BlkioWeight != "" ||
+		len(res.DeviceReadBps) > 0 || len(res.DeviceWriteBps) > 0 ||
+		len(res.DeviceReadIOps) > 0 || len(res.DeviceWriteIOps) > 0
+}
+
+//解析 --device-read-bps 等参数，格式 /dev/sda:1mb
+//bytes 为 true 时速率支持容量单位（bps），否则为整数（iops）
+func ParseThrottleDevices(devices []string, bytes bool) ([]*ThrottleDevice, error) {
+	throttleDevices := make([]*ThrottleDevice, 0, len(devices))
+	for _, device := range devices {
+		idx := strings.LastIndex(device, ":")
+		if idx <= 0 || idx == len(device)-1 {
+			return nil, fmt.Errorf("错误的设备限制参数 %s，格式为 <设备路径>:<速率>", device)
+		}
+		devicePath, rateStr := device[:idx], device[idx+1:]
+
+		var rate uint64
+		if bytes {
+			r, err := units.RAMInBytes(rateStr)
+			if err != nil || r < 0 {
+				return nil, fmt.Errorf("错误的设备限制速率 %s", device)
+			}
+			rate = uint64(r)
+		} else {
+			r, err := strconv.ParseUint(rateStr, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("错误的设备限制速率 %s", device)
+			}
+			rate = r
+		}
+
+		major, minor, err := getDeviceNumber(devicePath)
+		if err != nil {
+			return nil, err
+		}
+		throttleDevices = append(throttleDevices, &ThrottleDevice{
+			Major: major,
+			Minor: minor,
+			Rate:  rate,
+		})
+	}
+	return throttleDevices, nil
+}
+
+//获取块设备的 major:minor 设备号
+func getDeviceNumber(devicePath string) (uint32, uint32, error) {
+	var stat unix.Stat_t
+	if err := unix.Stat(devicePath, &stat); err != nil {
+		return 0, 0, fmt.Errorf("获取设备 %s 信息失败 %v", devicePath, err)
+	}
+	if stat.Mode&unix.S_IFMT != unix.S_IFBLK {
+		return 0, 0, fmt.Errorf("%s 不是块设备", devicePath)
+	}
+	return unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev)), nil
+}
+
+func (c *BlkioSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	content, err := ioutil.ReadFile(path.Join(cgroupAbsolutePath, "blkio.throttle.io_service_bytes"))
+	if err != nil {
+		return fmt.Errorf("读取 cgroup blkio.throttle.io_service_bytes 失败 %v", err)
+	}
+	//8:0 Read 1024
+	//8:0 Write 2048
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		value, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			stats.BlkioRead += value
+		case "Write":
+			stats.BlkioWrite += value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package subsystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+)
+
+//只用于统计 CPU 使用时间，不做限制
+type CpuacctSubSystem struct {
+}
+
+func (c *CpuacctSubSystem) Name() string {
+	return "cpuacct"
+}
+
+func (c *CpuacctSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	log.Debugf("设置 cgroup cpuacct 开始")
+	if _, err := GetCgroupPath(c.Name(), cgroupPath, true); err != nil {
+		log.Errorf("设置 cgroup cpuacct 失败 %v", err)
+		return err
+	}
+	return nil
+}
+
+func (c *CpuacctSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	log.Debugf("写入 cgroup cpuacct pid=%d 开始", pid)
+	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			log.Errorf("写入 cgroup cpuacct pid=%d 失败 %v", pid, err)
+			return fmt.Errorf("写入 cgroup cpuacct pid=%d 失败 %v", pid, err)
+		} else {
+			log.Debugf("写入 cgroup cpuacct pid=%d 成功", pid)
+			return nil
+		}
+	} else {
+		log.Errorf("写入 cgroup cpuacct pid=%d 失败 %v", pid, err)
+		return err
+	}
+}
+
+func (c *CpuacctSubSystem) Remove(cgroupPath string) error {
+	log.Debugf("删除 cgroup cpuacct 开始")
+	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(cgroupAbsolutePath)
+	} else {
+		log.Errorf("删除 cgroup cpuacct 失败 %v", err)
+		return err
+	}
+}
+
+func (c *CpuacctSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	usage, err := readUint(cgroupAbsolutePath, "cpuacct.usage")
+	if err != nil {
+		return fmt.Errorf("读取 cgroup cpuacct.usage 失败 %v", err)
+	}
+	stats.CpuUsage = usage
+	return nil
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 
 	log "github.com/RedDragonet/rocker/pkg/pidlog"
 )
@@ -21,8 +22,9 @@ func (c *CpuSetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	log.Infof("设置 cgroup cpu set 开始, %s,%b", res.CpuSet)
 	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, true); err == nil {
 		if res.CpuSet == "" {
-			log.Debugf("未配置 cgroup cpu set 跳过")
-			return nil
+			//未配置时继承父级的 cpus/mems，否则进程无法加入该 cgroup
+			log.Debugf("未配置 cgroup cpu set 继承父级配置")
+			return inheritCpuset(cgroupAbsolutePath)
 		}
 		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "cpuset.cpus"), []byte(res.CpuSet), 0644); err != nil {
 			log.Errorf("设置 cgroup cpu set 失败 %v", err)
@@ -42,10 +44,6 @@ func (c *CpuSetSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 }
 
 func (c *CpuSetSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
-	if res.CpuSet == "" {
-		log.Debugf("未配置 cgroup cpu set 跳过")
-		return nil
-	}
 	log.Infof("写入 cgroup cpu set pid=%d 开始", pid)
 	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
 		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
@@ -70,3 +68,28 @@ func (c *CpuSetSubSystem) Remove(cgroupPath string) error {
 		return err
 	}
 }
+
+//cpuset.cpus/cpuset.mems 为空时从父级复制
+func inheritCpuset(cgroupAbsolutePath string) error {
+	parent := path.Dir(cgroupAbsolutePath)
+	for _, file := range []string{"cpuset.cpus", "cpuset.mems"} {
+		current, err := ioutil.ReadFile(path.Join(cgroupAbsolutePath, file))
+		if err != nil {
+			log.Errorf("读取 cgroup %s 失败 %v", file, err)
+			return fmt.Errorf("读取 cgroup %s 失败 %v", file, err)
+		}
+		if strings.TrimSpace(string(current)) != "" {
+			continue
+		}
+		value, err := ioutil.ReadFile(path.Join(parent, file))
+		if err != nil {
+			log.Errorf("读取父级 cgroup %s 失败 %v", file, err)
+			return fmt.Errorf("读取父级 cgroup %s 失败 %v", file, err)
+		}
+		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, file), value, 0644); err != nil {
+			log.Errorf("设置 cgroup %s 失败 %v", file, err)
+			return fmt.Errorf("设置 cgroup %s 失败 %v", file, err)
+		}
+	}
+	return nil
+}
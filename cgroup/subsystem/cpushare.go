@@ -10,6 +10,9 @@ import (
 	log "github.com/RedDragonet/rocker/pkg/pidlog"
 )
 
+//默认 CFS 调度周期 100ms
+const defaultCpuPeriod = 100000
+
 type CpuSubSystem struct {
 }
 
@@ -20,17 +23,37 @@ func (c *CpuSubSystem) Name() string {
 func (c *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	log.Infof("设置 cgroup cpu share 开始，%s", res.CpuShare)
 	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, true); err == nil {
-		if res.CpuShare == "" {
+		if !cpuConfigured(res) {
 			log.Debugf("未配置 cgroup cpu share 跳过")
 			return nil
 		}
-		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "cpu.shares"), []byte(res.CpuShare), 0644); err != nil {
-			log.Errorf("设置 cgroup cpu share 失败 %v", err)
-			return fmt.Errorf("设置 cgroup cpu share 失败 %v", err)
-		} else {
+		if res.CpuShare != "" {
+			if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "cpu.shares"), []byte(res.CpuShare), 0644); err != nil {
+				log.Errorf("设置 cgroup cpu share 失败 %v", err)
+				return fmt.Errorf("设置 cgroup cpu share 失败 %v", err)
+			}
 			log.Debugf("设置 cgroup cpu share 成功")
-			return nil
 		}
+
+		quota, period, err := res.CpuQuotaAndPeriod()
+		if err != nil {
+			return err
+		}
+		//先设置周期，再设置周期内可使用的时间
+		if period != 0 {
+			if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "cpu.cfs_period_us"), []byte(strconv.FormatInt(period, 10)), 0644); err != nil {
+				log.Errorf("设置 cgroup cpu.cfs_period_us 失败 %v", err)
+				return fmt.Errorf("设置 cgroup cpu.cfs_period_us 失败 %v", err)
+			}
+		}
+		if quota != 0 {
+			if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "cpu.cfs_quota_us"), []byte(strconv.FormatInt(quota, 10)), 0644); err != nil {
+				log.Errorf("设置 cgroup cpu.cfs_quota_us 失败 %v", err)
+				return fmt.Errorf("设置 cgroup cpu.cfs_quota_us 失败 %v", err)
+			}
+		}
+		log.Debugf("设置 cgroup cpu quota=%d period=%d 成功", quota, period)
+		return nil
 	} else {
 		log.Errorf("设置 cgroup cpu share 失败 %v", err)
 		return err
@@ -38,10 +61,6 @@ func (c *CpuSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 }
 
 func (c *CpuSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
-	if res.CpuShare == "" {
-		log.Debugf("未配置 cgroup cpu share 跳过")
-		return nil
-	}
 	log.Infof("写入 cgroup cpu share pid=%d 开始", pid)
 	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
 		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
@@ -66,3 +85,33 @@ func (c *CpuSubSystem) Remove(cgroupPath string) error {
 		return err
 	}
 }
+
+func cpuConfigured(res *ResourceConfig) bool {
+	return res.CpuShare != "" || res.Cpus != "" || res.CpuQuota != "" || res.CpuPeriod != ""
+}
+
+//计算实际生效的 CFS quota 与 period（微秒），0 表示未配置
+//--cpus 1.5 等价于 period=100000 quota=150000
+func (res *ResourceConfig) CpuQuotaAndPeriod() (quota int64, period int64, err error) {
+	if res.Cpus != "" {
+		cpus, err := strconv.ParseFloat(res.Cpus, 64)
+		if err != nil || cpus <= 0 {
+			return 0, 0, fmt.Errorf("cpus 无效 %s", res.Cpus)
+		}
+		period = defaultCpuPeriod
+		quota = int64(cpus * float64(period))
+		return quota, period, nil
+	}
+
+	if res.CpuQuota != "" {
+		if quota, err = strconv.ParseInt(res.CpuQuota, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("cpu quota 无效 %s", res.CpuQuota)
+		}
+	}
+	if res.CpuPeriod != "" {
+		if period, err = strconv.ParseInt(res.CpuPeriod, 10, 64); err != nil {
+			return 0, 0, fmt.Errorf("cpu period 无效 %s", res.CpuPeriod)
+		}
+	}
+	return quota, period, nil
+}
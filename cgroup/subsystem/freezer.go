@@ -0,0 +1,124 @@
+package subsystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+)
+
+const (
+	Frozen = "FROZEN"
+	Thawed = "THAWED"
+)
+
+type FreezerSubSystem struct {
+}
+
+func (c *FreezerSubSystem) Name() string {
+	return "freezer"
+}
+
+func (c *FreezerSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	log.Debugf("设置 cgroup freezer 开始")
+	if _, err := GetCgroupPath(c.Name(), cgroupPath, true); err == nil {
+		log.Debugf("设置 cgroup freezer 成功")
+		return nil
+	} else {
+		log.Errorf("设置 cgroup freezer 失败 %v", err)
+		return err
+	}
+}
+
+func (c *FreezerSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	log.Debugf("写入 cgroup freezer pid=%d 开始", pid)
+	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			log.Errorf("写入 cgroup freezer pid=%d 失败 %v", pid, err)
+			return fmt.Errorf("写入 cgroup freezer pid=%d 失败 %v", pid, err)
+		} else {
+			log.Debugf("写入 cgroup freezer pid=%d 成功", pid)
+			return nil
+		}
+	} else {
+		log.Errorf("写入 cgroup freezer pid=%d 失败 %v", pid, err)
+		return err
+	}
+}
+
+func (c *FreezerSubSystem) Remove(cgroupPath string) error {
+	log.Debugf("删除 cgroup freezer 开始")
+	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(cgroupAbsolutePath)
+	} else {
+		log.Errorf("删除 cgroup freezer 失败 %v", err)
+		return err
+	}
+}
+
+//冻结（FROZEN）或恢复（THAWED）cgroup 中的所有进程
+//v1 写入 freezer.state，v2 写入 cgroup.freeze
+func SetFreezerState(cgroupPath string, state string) error {
+	if state != Frozen && state != Thawed {
+		return fmt.Errorf("错误的 freezer 状态 %s", state)
+	}
+	if IsCgroup2UnifiedMode() {
+		return setUnifiedFreezerState(cgroupPath, state)
+	}
+
+	cgroupAbsolutePath, err := GetCgroupPath("freezer", cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	//冻结需要时间，期间状态为 FREEZING，重复写入直到状态一致
+	for i := 0; i < 1000; i++ {
+		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "freezer.state"), []byte(state), 0644); err != nil {
+			log.Errorf("设置 cgroup freezer.state=%s 失败 %v", state, err)
+			return fmt.Errorf("设置 cgroup freezer.state=%s 失败 %v", state, err)
+		}
+		content, err := ioutil.ReadFile(path.Join(cgroupAbsolutePath, "freezer.state"))
+		if err != nil {
+			return fmt.Errorf("读取 cgroup freezer.state 失败 %v", err)
+		}
+		if strings.TrimSpace(string(content)) == state {
+			log.Debugf("设置 cgroup freezer.state=%s 成功", state)
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	log.Errorf("设置 cgroup freezer.state=%s 超时", state)
+	return fmt.Errorf("设置 cgroup freezer.state=%s 超时", state)
+}
+
+func setUnifiedFreezerState(cgroupPath string, state string) error {
+	cgroupAbsolutePath, err := GetCgroupPath("unified", cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	freeze, frozen := "0", uint64(0)
+	if state == Frozen {
+		freeze, frozen = "1", 1
+	}
+	if err := writeCgroupFile(cgroupAbsolutePath, "cgroup.freeze", freeze); err != nil {
+		return err
+	}
+	//cgroup.events 中 frozen 1 表示所有进程已冻结
+	for i := 0; i < 1000; i++ {
+		events, err := readKeyValue(cgroupAbsolutePath, "cgroup.events")
+		if err != nil {
+			return fmt.Errorf("读取 cgroup cgroup.events 失败 %v", err)
+		}
+		if events["frozen"] == frozen {
+			log.Debugf("设置 cgroup cgroup.freeze=%s 成功", freeze)
+			return nil
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	log.Errorf("设置 cgroup cgroup.freeze=%s 超时", freeze)
+	return fmt.Errorf("设置 cgroup cgroup.freeze=%s 超时", freeze)
+}
@@ -6,10 +6,18 @@ import (
 	"os"
 	"path"
 	"strconv"
+	"strings"
 
 	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"github.com/RedDragonet/rocker/pkg/units"
 )
 
+//允许的最小内存限制
+const minMemoryLimit = 6 * units.MiB
+
+//允许的最小内核内存限制
+const minKernelMemoryLimit = 4 * units.MiB
+
 type MemorySubSystem struct {
 }
 
@@ -20,17 +28,41 @@ func (c *MemorySubSystem) Name() string {
 func (c *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 	log.Debugf("设置 cgroup memory 开始，%s", res.MemoryLimit)
 	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, true); err == nil {
-		if res.MemoryLimit == "" {
+		if !memoryConfigured(res) {
 			log.Debugf("未配置 cgroup memory 跳过")
 			return nil
 		}
-		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "memory.limit_in_bytes"), []byte(res.MemoryLimit), 0644); err != nil {
+		if err := setMemoryAndSwap(cgroupAbsolutePath, res); err != nil {
 			log.Errorf("设置 cgroup memory 失败 %v", err)
 			return fmt.Errorf("设置 cgroup memory 失败 %v", err)
-		} else {
-			log.Debugf("设置 cgroup memory 成功")
-			return nil
 		}
+		if res.MemoryReservation != "" {
+			reservation, _ := ParseMemory(res.MemoryReservation)
+			if err := writeMemoryFile(cgroupAbsolutePath, "memory.soft_limit_in_bytes", reservation); err != nil {
+				return err
+			}
+		}
+		if res.KernelMemory != "" {
+			//内核内存限制需要在进程加入 cgroup 之前设置
+			kernelMemory, _ := ParseMemory(res.KernelMemory)
+			if err := writeMemoryFile(cgroupAbsolutePath, "memory.kmem.limit_in_bytes", kernelMemory); err != nil {
+				return err
+			}
+		}
+		if res.MemorySwappiness != "" {
+			if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "memory.swappiness"), []byte(res.MemorySwappiness), 0644); err != nil {
+				log.Errorf("设置 cgroup memory.swappiness 失败 %v", err)
+				return fmt.Errorf("设置 cgroup memory.swappiness 失败 %v", err)
+			}
+		}
+		if res.OomKillDisable {
+			if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "memory.oom_control"), []byte("1"), 0644); err != nil {
+				log.Errorf("设置 cgroup memory.oom_control 失败 %v", err)
+				return fmt.Errorf("设置 cgroup memory.oom_control 失败 %v", err)
+			}
+		}
+		log.Debugf("设置 cgroup memory 成功")
+		return nil
 	} else {
 		log.Errorf("设置 cgroup memory 失败 %v", err)
 		return err
@@ -38,10 +70,6 @@ func (c *MemorySubSystem) Set(cgroupPath string, res *ResourceConfig) error {
 }
 
 func (c *MemorySubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
-	if res.MemoryLimit == "" {
-		log.Debugf("未配置 cgroup memory 跳过")
-		return nil
-	}
 	log.Debugf("写入 cgroup memory pid=%d 开始", pid)
 	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
 		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
@@ -66,3 +94,143 @@ func (c *MemorySubSystem) Remove(cgroupPath string) error {
 		return err
 	}
 }
+
+func memoryConfigured(res *ResourceConfig) bool {
+	return res.MemoryLimit != "" || res.MemorySwap != "" || res.MemoryReservation != "" ||
+		res.MemorySwappiness != "" || res.KernelMemory != "" || res.OomKillDisable
+}
+
+//memory.limit_in_bytes 不能大于 memory.memsw.limit_in_bytes
+//根据当前值决定写入顺序，避免更新时被内核拒绝
+//https://github.com/opencontainers/runc/blob/master/libcontainer/cgroups/fs/memory.go
+func setMemoryAndSwap(cgroupAbsolutePath string, res *ResourceConfig) error {
+	var limit, swap int64
+	if res.MemoryLimit != "" {
+		limit, _ = ParseMemory(res.MemoryLimit)
+	}
+	if res.MemorySwap != "" {
+		swap, _ = ParseMemory(res.MemorySwap)
+	}
+
+	if limit != 0 && swap != 0 {
+		current, err := readMemoryFile(cgroupAbsolutePath, "memory.limit_in_bytes")
+		if err != nil {
+			return err
+		}
+		//新的 swap 更大时，先设置 swap
+		if swap == -1 || current < swap {
+			if err := writeMemoryFile(cgroupAbsolutePath, "memory.memsw.limit_in_bytes", swap); err != nil {
+				return err
+			}
+			return writeMemoryFile(cgroupAbsolutePath, "memory.limit_in_bytes", limit)
+		}
+	}
+
+	if limit != 0 {
+		if err := writeMemoryFile(cgroupAbsolutePath, "memory.limit_in_bytes", limit); err != nil {
+			return err
+		}
+	}
+	if swap != 0 {
+		if err := writeMemoryFile(cgroupAbsolutePath, "memory.memsw.limit_in_bytes", swap); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeMemoryFile(cgroupAbsolutePath, file string, value int64) error {
+	if _, err := os.Stat(path.Join(cgroupAbsolutePath, file)); os.IsNotExist(err) {
+		log.Errorf("设置 cgroup %s 失败，内核不支持", file)
+		return fmt.Errorf("设置 cgroup %s 失败，内核不支持（swap 限制需要开启 swapaccount=1）", file)
+	}
+	if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, file), []byte(strconv.FormatInt(value, 10)), 0644); err != nil {
+		log.Errorf("设置 cgroup %s=%d 失败 %v", file, value, err)
+		return fmt.Errorf("设置 cgroup %s=%d 失败 %v", file, value, err)
+	}
+	log.Debugf("设置 cgroup %s=%d 成功", file, value)
+	return nil
+}
+
+func readMemoryFile(cgroupAbsolutePath, file string) (int64, error) {
+	content, err := ioutil.ReadFile(path.Join(cgroupAbsolutePath, file))
+	if err != nil {
+		return 0, fmt.Errorf("读取 cgroup %s 失败 %v", file, err)
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return -1, nil
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+//解析内存大小，-1 表示不限制
+func ParseMemory(memory string) (int64, error) {
+	if memory == "-1" {
+		return -1, nil
+	}
+	return units.RAMInBytes(memory)
+}
+
+//获取 cgroup 当前的内存使用量
+func GetMemoryUsage(cgroupPath string) (int64, error) {
+	file := "memory.usage_in_bytes"
+	if IsCgroup2UnifiedMode() {
+		file = "memory.current"
+	}
+	cgroupAbsolutePath, err := GetCgroupPath("memory", cgroupPath, false)
+	if err != nil {
+		return 0, err
+	}
+	return readMemoryFile(cgroupAbsolutePath, file)
+}
+
+//获取 cgroup 中被 OOM Killer 杀死的进程数
+func GetOOMKillCount(cgroupPath string) (uint64, error) {
+	file := "memory.oom_control"
+	if IsCgroup2UnifiedMode() {
+		file = "memory.events"
+	}
+	cgroupAbsolutePath, err := GetCgroupPath("memory", cgroupPath, false)
+	if err != nil {
+		return 0, err
+	}
+	//oom_kill_disable 0
+	//under_oom 0
+	//oom_kill 1
+	values, err := readKeyValue(cgroupAbsolutePath, file)
+	if err != nil {
+		return 0, fmt.Errorf("读取 cgroup %s 失败 %v", file, err)
+	}
+	return values["oom_kill"], nil
+}
+
+func (c *MemorySubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	usage, err := readUint(cgroupAbsolutePath, "memory.usage_in_bytes")
+	if err != nil {
+		return fmt.Errorf("读取 cgroup memory.usage_in_bytes 失败 %v", err)
+	}
+	limit, err := readUint(cgroupAbsolutePath, "memory.limit_in_bytes")
+	if err != nil {
+		return fmt.Errorf("读取 cgroup memory.limit_in_bytes 失败 %v", err)
+	}
+	memoryStat, err := readKeyValue(cgroupAbsolutePath, "memory.stat")
+	if err != nil {
+		return fmt.Errorf("读取 cgroup memory.stat 失败 %v", err)
+	}
+	stats.MemoryUsage = subtractCache(usage, memoryStat["total_inactive_file"])
+	stats.MemoryLimit = limit
+	return nil
+}
+
+//与 docker stats 一致，内存使用量不包含可回收的文件缓存
+func subtractCache(usage, inactiveFile uint64) uint64 {
+	if inactiveFile < usage {
+		return usage - inactiveFile
+	}
+	return usage
+}
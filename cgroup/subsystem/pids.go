@@ -0,0 +1,99 @@
+package subsystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+)
+
+type PidsSubSystem struct {
+}
+
+func (c *PidsSubSystem) Name() string {
+	return "pids"
+}
+
+func (c *PidsSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	log.Debugf("设置 cgroup pids 开始，%s", res.PidsLimit)
+	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, true); err == nil {
+		if res.PidsLimit == "" {
+			log.Debugf("未配置 cgroup pids 跳过")
+			return nil
+		}
+		limit, err := pidsLimitValue(res.PidsLimit)
+		if err != nil {
+			log.Errorf("设置 cgroup pids 失败 %v", err)
+			return err
+		}
+		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "pids.max"), []byte(limit), 0644); err != nil {
+			log.Errorf("设置 cgroup pids 失败 %v", err)
+			return fmt.Errorf("设置 cgroup pids 失败 %v", err)
+		} else {
+			log.Debugf("设置 cgroup pids 成功")
+			return nil
+		}
+	} else {
+		log.Errorf("设置 cgroup pids 失败 %v", err)
+		return err
+	}
+}
+
+func (c *PidsSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	log.Debugf("写入 cgroup pids pid=%d 开始", pid)
+	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "tasks"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			log.Errorf("写入 cgroup pids pid=%d 失败 %v", pid, err)
+			return fmt.Errorf("写入 cgroup pids pid=%d 失败 %v", pid, err)
+		} else {
+			log.Debugf("写入 cgroup pids pid=%d 成功", pid)
+			return nil
+		}
+	} else {
+		log.Errorf("写入 cgroup pids pid=%d 失败 %v", pid, err)
+		return err
+	}
+}
+
+func (c *PidsSubSystem) Remove(cgroupPath string) error {
+	log.Debugf("删除 cgroup pids 开始")
+	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(cgroupAbsolutePath)
+	} else {
+		log.Errorf("删除 cgroup pids 失败 %v", err)
+		return err
+	}
+}
+
+//pids.max 的值，小于等于 0 时表示不限制
+func pidsLimitValue(pidsLimit string) (string, error) {
+	limit, err := strconv.ParseInt(pidsLimit, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("pids limit 无效 %s", pidsLimit)
+	}
+	if limit <= 0 {
+		return "max", nil
+	}
+	return strconv.FormatInt(limit, 10), nil
+}
+
+func (c *PidsSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+	current, err := readUint(cgroupAbsolutePath, "pids.current")
+	if err != nil {
+		return fmt.Errorf("读取 cgroup pids.current 失败 %v", err)
+	}
+	limit, err := readUint(cgroupAbsolutePath, "pids.max")
+	if err != nil {
+		return fmt.Errorf("读取 cgroup pids.max 失败 %v", err)
+	}
+	stats.PidsCurrent = current
+	stats.PidsLimit = limit
+	return nil
+}
@@ -0,0 +1,64 @@
+package subsystem
+
+import (
+	"bufio"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+//cgroup 资源使用统计
+type Stats struct {
+	//累计使用的 CPU 时间，单位纳秒
+	CpuUsage uint64
+	//内存使用量（不含可回收的 inactive_file 缓存）与上限，单位字节
+	MemoryUsage uint64
+	MemoryLimit uint64
+	PidsCurrent uint64
+	PidsLimit   uint64
+	//块设备累计读写字节数
+	BlkioRead  uint64
+	BlkioWrite uint64
+}
+
+//支持资源统计的 subsystem
+type StatsSubsystem interface {
+	GetStats(path string, stats *Stats) error
+}
+
+//读取只有一个数字的 cgroup 文件，max 返回 0
+func readUint(dir, file string) (uint64, error) {
+	content, err := ioutil.ReadFile(path.Join(dir, file))
+	if err != nil {
+		return 0, err
+	}
+	value := strings.TrimSpace(string(content))
+	if value == "max" {
+		return 0, nil
+	}
+	return strconv.ParseUint(value, 10, 64)
+}
+
+//读取 key value 格式的 cgroup 文件，如 memory.stat、cpu.stat
+func readKeyValue(dir, file string) (map[string]uint64, error) {
+	f, err := os.Open(path.Join(dir, file))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	values := map[string]uint64{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+		if v, err := strconv.ParseUint(fields[1], 10, 64); err == nil {
+			values[fields[0]] = v
+		}
+	}
+	return values, scanner.Err()
+}
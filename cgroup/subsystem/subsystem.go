@@ -1,9 +1,28 @@
 package subsystem
 
 type ResourceConfig struct {
-	MemoryLimit string
-	CpuShare    string
-	CpuSet      string
+	//内存相关的大小支持 k/m/g 单位，-1 表示不限制
+	MemoryLimit       string
+	MemorySwap        string
+	MemoryReservation string
+	MemorySwappiness  string
+	//内核内存上限，只有 cgroup v1 支持
+	KernelMemory   string
+	OomKillDisable bool
+
+	CpuShare string
+	//--cpus 与 cpu quota/period 不能同时设置，单位为微秒
+	Cpus      string
+	CpuQuota  string
+	CpuPeriod string
+	CpuSet    string
+	PidsLimit string
+	//块设备 IO 权重及读写限制，设备限制格式为 /dev/sda:1mb
+	BlkioWeight     string
+	DeviceReadBps   []string
+	DeviceWriteBps  []string
+	DeviceReadIOps  []string
+	DeviceWriteIOps []string
 }
 
 type Subsystem interface {
@@ -18,5 +37,22 @@ var (
 		&CpuSetSubSystem{},
 		&MemorySubSystem{},
 		&CpuSubSystem{},
+		&CpuacctSubSystem{},
+		&PidsSubSystem{},
+		&BlkioSubSystem{},
+		&FreezerSubSystem{},
+	}
+
+	//cgroup v2 所有控制器共用同一个目录
+	UnifiedSubsystemIns = []Subsystem{
+		&UnifiedSubSystem{},
 	}
 )
+
+//根据当前系统的 cgroup 层级返回对应的 subsystem
+func GetSubsystemIns() []Subsystem {
+	if IsCgroup2UnifiedMode() {
+		return UnifiedSubsystemIns
+	}
+	return SubsystemIns
+}
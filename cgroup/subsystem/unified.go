@@ -0,0 +1,355 @@
+package subsystem
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+)
+
+//cgroup v2 统一层级（unified hierarchy）
+//所有控制器共用同一个目录，子 cgroup 可用的控制器需要在父 cgroup 的 cgroup.subtree_control 中开启
+//https://www.kernel.org/doc/html/latest/admin-guide/cgroup-v2.html
+type UnifiedSubSystem struct {
+}
+
+//需要在子 cgroup 中开启的控制器
+var unifiedControllers = []string{"cpuset", "cpu", "memory", "pids", "io"}
+
+func (c *UnifiedSubSystem) Name() string {
+	return "unified"
+}
+
+func (c *UnifiedSubSystem) Set(cgroupPath string, res *ResourceConfig) error {
+	log.Infof("设置 cgroup v2 开始, %+v", *res)
+	if err := enableControllers(cgroupPath); err != nil {
+		log.Errorf("设置 cgroup v2 开启控制器失败 %v", err)
+		return err
+	}
+
+	cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, true)
+	if err != nil {
+		log.Errorf("设置 cgroup v2 失败 %v", err)
+		return err
+	}
+
+	if memoryConfigured(res) {
+		if err := setMemory(cgroupAbsolutePath, res); err != nil {
+			return err
+		}
+	}
+
+	if res.CpuShare != "" {
+		shares, err := strconv.ParseUint(res.CpuShare, 10, 64)
+		if err != nil {
+			log.Errorf("设置 cgroup v2 cpu.weight 失败，cpu share 无效 %s", res.CpuShare)
+			return fmt.Errorf("设置 cgroup v2 cpu.weight 失败，cpu share 无效 %s", res.CpuShare)
+		}
+		weight := convertCPUSharesToWeight(shares)
+		if err := writeCgroupFile(cgroupAbsolutePath, "cpu.weight", strconv.FormatUint(weight, 10)); err != nil {
+			return err
+		}
+	}
+
+	quota, period, err := res.CpuQuotaAndPeriod()
+	if err != nil {
+		return err
+	}
+	if quota != 0 || period != 0 {
+		//cpu.max 格式为 "$MAX $PERIOD"，不限制时为 max
+		cpuMax := "max"
+		if quota > 0 {
+			cpuMax = strconv.FormatInt(quota, 10)
+		}
+		if period == 0 {
+			period = defaultCpuPeriod
+		}
+		if err := writeCgroupFile(cgroupAbsolutePath, "cpu.max", cpuMax+" "+strconv.FormatInt(period, 10)); err != nil {
+			return err
+		}
+	}
+
+	if res.CpuSet != "" {
+		if err := writeCgroupFile(cgroupAbsolutePath, "cpuset.cpus", res.CpuSet); err != nil {
+			return err
+		}
+		//与 v1 保持一致，只使用 0 号内存节点
+		if err := writeCgroupFile(cgroupAbsolutePath, "cpuset.mems", "0"); err != nil {
+			return err
+		}
+	}
+
+	if res.PidsLimit != "" {
+		limit, err := pidsLimitValue(res.PidsLimit)
+		if err != nil {
+			log.Errorf("设置 cgroup v2 pids.max 失败 %v", err)
+			return err
+		}
+		if err := writeCgroupFile(cgroupAbsolutePath, "pids.max", limit); err != nil {
+			return err
+		}
+	}
+
+	if blkioConfigured(res) {
+		if err := setIo(cgroupAbsolutePath, res); err != nil {
+			return err
+		}
+	}
+
+	log.Debugf("设置 cgroup v2 成功")
+	return nil
+}
+
+func (c *UnifiedSubSystem) Apply(cgroupPath string, pid int, res *ResourceConfig) error {
+	log.Infof("写入 cgroup v2 pid=%d 开始", pid)
+	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		if err := ioutil.WriteFile(path.Join(cgroupAbsolutePath, "cgroup.procs"), []byte(strconv.Itoa(pid)), 0644); err != nil {
+			log.Errorf("写入 cgroup v2 pid=%d 失败 %v", pid, err)
+			return fmt.Errorf("写入 cgroup v2 pid=%d 失败 %v", pid, err)
+		} else {
+			log.Debugf("写入 cgroup v2 pid=%d 成功", pid)
+			return nil
+		}
+	} else {
+		log.Errorf("写入 cgroup v2 pid=%d 失败 %v", pid, err)
+		return err
+	}
+}
+
+func (c *UnifiedSubSystem) Remove(cgroupPath string) error {
+	log.Infof("删除 cgroup v2 开始")
+	if cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false); err == nil {
+		return os.RemoveAll(cgroupAbsolutePath)
+	} else {
+		log.Errorf("删除 cgroup v2 失败 %v", err)
+		return err
+	}
+}
+
+func (c *UnifiedSubSystem) GetStats(cgroupPath string, stats *Stats) error {
+	cgroupAbsolutePath, err := GetCgroupPath(c.Name(), cgroupPath, false)
+	if err != nil {
+		return err
+	}
+
+	cpuStat, err := readKeyValue(cgroupAbsolutePath, "cpu.stat")
+	if err != nil {
+		return fmt.Errorf("读取 cgroup v2 cpu.stat 失败 %v", err)
+	}
+	stats.CpuUsage = cpuStat["usage_usec"] * 1000
+
+	//控制器未开启时不存在对应文件，忽略
+	if usage, err := readUint(cgroupAbsolutePath, "memory.current"); err == nil {
+		memoryStat, _ := readKeyValue(cgroupAbsolutePath, "memory.stat")
+		stats.MemoryUsage = subtractCache(usage, memoryStat["inactive_file"])
+		stats.MemoryLimit, _ = readUint(cgroupAbsolutePath, "memory.max")
+	}
+	if current, err := readUint(cgroupAbsolutePath, "pids.current"); err == nil {
+		stats.PidsCurrent = current
+		stats.PidsLimit, _ = readUint(cgroupAbsolutePath, "pids.max")
+	}
+	if content, err := ioutil.ReadFile(path.Join(cgroupAbsolutePath, "io.stat")); err == nil {
+		//8:0 rbytes=1024 wbytes=2048 rios=1 wios=2 dbytes=0 dios=0
+		for _, line := range strings.Split(string(content), "\n") {
+			for _, field := range strings.Fields(line) {
+				kv := strings.SplitN(field, "=", 2)
+				if len(kv) != 2 {
+					continue
+				}
+				value, err := strconv.ParseUint(kv[1], 10, 64)
+				if err != nil {
+					continue
+				}
+				switch kv[0] {
+				case "rbytes":
+					stats.BlkioRead += value
+				case "wbytes":
+					stats.BlkioWrite += value
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//从根 cgroup 开始，逐级在 cgroup.subtree_control 中开启控制器，直到 cgroupPath 的父级
+func enableControllers(cgroupPath string) error {
+	root, err := FindCgroupMountPoint("")
+	if err != nil {
+		return err
+	}
+
+	content, err := ioutil.ReadFile(path.Join(root, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("读取 cgroup.controllers 失败 %v", err)
+	}
+	available := strings.Fields(string(content))
+
+	var controllers []string
+	for _, controller := range unifiedControllers {
+		for _, a := range available {
+			if a == controller {
+				controllers = append(controllers, controller)
+				break
+			}
+		}
+	}
+
+	dir := root
+	elems := strings.Split(strings.Trim(path.Clean(cgroupPath), "/"), "/")
+	for i := 0; i < len(elems); i++ {
+		if i > 0 {
+			dir = path.Join(dir, elems[i-1])
+			if _, err := os.Stat(dir); os.IsNotExist(err) {
+				if err := os.Mkdir(dir, 0755); err != nil {
+					return err
+				}
+			}
+		}
+		for _, controller := range controllers {
+			//控制器可能已经被占用（如 cpuset 被其他程序限制），逐个开启，失败时只提示
+			if err := ioutil.WriteFile(path.Join(dir, "cgroup.subtree_control"), []byte("+"+controller), 0644); err != nil {
+				log.Warnf("开启 cgroup v2 控制器 %s 失败 %s %v", controller, dir, err)
+			}
+		}
+	}
+	return nil
+}
+
+//cgroup v2 中 memory.swap.max 只限制 swap，不包含内存
+func setMemory(cgroupAbsolutePath string, res *ResourceConfig) error {
+	var limit int64
+	if res.MemoryLimit != "" {
+		limit, _ = ParseMemory(res.MemoryLimit)
+		if err := writeCgroupFile(cgroupAbsolutePath, "memory.max", memoryValue(limit)); err != nil {
+			return err
+		}
+	}
+
+	if res.MemorySwap != "" {
+		swap, _ := ParseMemory(res.MemorySwap)
+		if swap != -1 && limit > 0 {
+			swap = swap - limit
+		}
+		if err := writeCgroupFile(cgroupAbsolutePath, "memory.swap.max", memoryValue(swap)); err != nil {
+			return err
+		}
+	}
+
+	if res.MemoryReservation != "" {
+		reservation, _ := ParseMemory(res.MemoryReservation)
+		if err := writeCgroupFile(cgroupAbsolutePath, "memory.low", memoryValue(reservation)); err != nil {
+			return err
+		}
+	}
+
+	if res.MemorySwappiness != "" {
+		log.Warnf("cgroup v2 不支持 memory swappiness，忽略")
+	}
+	if res.OomKillDisable {
+		log.Warnf("cgroup v2 不支持关闭 OOM Killer，忽略")
+	}
+	return nil
+}
+
+func memoryValue(value int64) string {
+	if value == -1 {
+		return "max"
+	}
+	return strconv.FormatInt(value, 10)
+}
+
+//cgroup v2 中 blkio 对应 io.weight 和 io.max
+func setIo(cgroupAbsolutePath string, res *ResourceConfig) error {
+	if res.BlkioWeight != "" {
+		weight, err := strconv.ParseUint(res.BlkioWeight, 10, 64)
+		if err != nil {
+			log.Errorf("设置 cgroup v2 io.weight 失败，blkio weight 无效 %s", res.BlkioWeight)
+			return fmt.Errorf("设置 cgroup v2 io.weight 失败，blkio weight 无效 %s", res.BlkioWeight)
+		}
+		//io.weight 需要内核开启 blk-iocost，未开启时使用 BFQ 的 io.bfq.weight
+		if _, err := os.Stat(path.Join(cgroupAbsolutePath, "io.weight")); err == nil {
+			err = writeCgroupFile(cgroupAbsolutePath, "io.weight", "default "+strconv.FormatUint(convertBlkIOToIOWeight(weight), 10))
+		} else {
+			err = writeCgroupFile(cgroupAbsolutePath, "io.bfq.weight", res.BlkioWeight)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	throttles := []struct {
+		key     string
+		devices []string
+		bytes   bool
+	}{
+		{"rbps", res.DeviceReadBps, true},
+		{"wbps", res.DeviceWriteBps, true},
+		{"riops", res.DeviceReadIOps, false},
+		{"wiops", res.DeviceWriteIOps, false},
+	}
+
+	//同一设备的多个限制合并为一行 8:0 rbps=1048576 wiops=100
+	limits := map[string][]string{}
+	var devices []string
+	for _, throttle := range throttles {
+		throttleDevices, err := ParseThrottleDevices(throttle.devices, throttle.bytes)
+		if err != nil {
+			log.Errorf("设置 cgroup v2 io.max 失败 %v", err)
+			return err
+		}
+		for _, device := range throttleDevices {
+			dev := fmt.Sprintf("%d:%d", device.Major, device.Minor)
+			if _, ok := limits[dev]; !ok {
+				devices = append(devices, dev)
+			}
+			rate := "max"
+			if device.Rate > 0 {
+				rate = strconv.FormatUint(device.Rate, 10)
+			}
+			limits[dev] = append(limits[dev], throttle.key+"="+rate)
+		}
+	}
+	for _, dev := range devices {
+		if err := writeCgroupFile(cgroupAbsolutePath, "io.max", dev+" "+strings.Join(limits[dev], " ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeCgroupFile(dir, file, data string) error {
+	if err := ioutil.WriteFile(path.Join(dir, file), []byte(data), 0644); err != nil {
+		log.Errorf("设置 cgroup v2 %s=%s 失败 %v", file, data, err)
+		return fmt.Errorf("设置 cgroup v2 %s=%s 失败 %v", file, data, err)
+	}
+	log.Debugf("设置 cgroup v2 %s=%s 成功", file, data)
+	return nil
+}
+
+//cpu.shares [2, 262144] 转换为 cpu.weight [1, 10000]
+//https://github.com/opencontainers/runc/blob/master/libcontainer/cgroups/utils.go
+func convertCPUSharesToWeight(shares uint64) uint64 {
+	if shares == 0 {
+		return 0
+	}
+	if shares < 2 {
+		shares = 2
+	}
+	return 1 + ((shares-2)*9999)/262142
+}
+
+//blkio.weight [10, 1000] 转换为 io.weight [1, 10000]
+func convertBlkIOToIOWeight(blkioWeight uint64) uint64 {
+	if blkioWeight == 0 {
+		return 0
+	}
+	if blkioWeight < 10 {
+		blkioWeight = 10
+	}
+	return 1 + (blkioWeight-10)*9999/990
+}
@@ -3,14 +3,42 @@ package subsystem
 import (
 	"bufio"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"path"
+	"strconv"
 	"strings"
+	"sync"
 
 	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"golang.org/x/sys/unix"
 )
 
+//cgroup 默认挂载点
+const cgroupMountPoint = "/sys/fs/cgroup"
+
+var (
+	unifiedModeOnce sync.Once
+	unifiedMode     bool
+)
+
+//判断当前系统是否为 cgroup v2 统一层级（unified hierarchy）
+//混合模式（/sys/fs/cgroup 为 tmpfs，v2 挂载在 /sys/fs/cgroup/unified）按 v1 处理
+func IsCgroup2UnifiedMode() bool {
+	unifiedModeOnce.Do(func() {
+		var st unix.Statfs_t
+		if err := unix.Statfs(cgroupMountPoint, &st); err != nil {
+			log.Errorf("检测 cgroup 层级 %s 失败 %v", cgroupMountPoint, err)
+			return
+		}
+		unifiedMode = st.Type == unix.CGROUP2_SUPER_MAGIC
+		log.Debugf("检测 cgroup 层级 unified=%v", unifiedMode)
+	})
+	return unifiedMode
+}
+
 //查找对应 subsystem 的挂载点
+//cgroup v2 下所有控制器共用同一个 cgroup2 挂载点
 func FindCgroupMountPoint(subsystem string) (string, error) {
 	log.Debugf("查找对应 subsystem 的挂载点 %s 开始", subsystem)
 
@@ -21,10 +49,19 @@ func FindCgroupMountPoint(subsystem string) (string, error) {
 	}
 	defer f.Close()
 
+	unified := IsCgroup2UnifiedMode()
 	scanner := bufio.NewScanner(f)
 	for scanner.Scan() {
 		txt := scanner.Text()
 		fields := strings.Split(txt, " ")
+		//38 32 0:33 / /sys/fs/cgroup rw,nosuid,nodev,noexec,relatime shared:9 - cgroup2 cgroup2 rw,nsdelegate
+		if unified {
+			if len(fields) > 3 && fields[len(fields)-3] == "cgroup2" {
+				log.Debugf("查找对应 subsystem 的挂载点 %s 成功 %s", subsystem, fields[4])
+				return fields[4], nil
+			}
+			continue
+		}
 		//
 		for _, opt := range strings.Split(fields[len(fields)-1], ",") {
 			if opt == subsystem {
@@ -72,3 +109,39 @@ func GetCgroupPath(subsystem string, cgroupPath string, autoCreate bool) (string
 	log.Debugf("获取/创建 cgroup 对应 subsystem 的目录 已存在", cgroupAbsolutePath)
 	return cgroupAbsolutePath, nil
 }
+
+//cgroup 目录是否存在
+func CgroupExists(subsystem string, cgroupPath string) bool {
+	cgroupRoot, err := FindCgroupMountPoint(subsystem)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(path.Join(cgroupRoot, cgroupPath))
+	return err == nil
+}
+
+//获取 cgroup 中的所有进程，v1 从 freezer 层级读取
+func GetCgroupPids(cgroupPath string) ([]int, error) {
+	subsystem := "freezer"
+	if IsCgroup2UnifiedMode() {
+		subsystem = "unified"
+	}
+	cgroupAbsolutePath, err := GetCgroupPath(subsystem, cgroupPath, false)
+	if err != nil {
+		return nil, err
+	}
+	content, err := ioutil.ReadFile(path.Join(cgroupAbsolutePath, "cgroup.procs"))
+	if err != nil {
+		return nil, fmt.Errorf("读取 cgroup.procs 失败 %v", err)
+	}
+
+	var pids []int
+	for _, line := range strings.Fields(string(content)) {
+		pid, err := strconv.Atoi(line)
+		if err != nil {
+			continue
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
@@ -0,0 +1,121 @@
+package subsystem
+
+import (
+	"fmt"
+	"runtime"
+	"strconv"
+
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+)
+
+//校验资源配置，需要在创建 cgroup 目录之前调用
+func (res *ResourceConfig) Validate() error {
+	var memory int64
+	if res.MemoryLimit != "" {
+		m, err := ParseMemory(res.MemoryLimit)
+		if err != nil {
+			return fmt.Errorf("内存上限无效 %v", err)
+		}
+		if m != -1 && m < minMemoryLimit {
+			return fmt.Errorf("内存上限不能小于 6MB")
+		}
+		memory = m
+	}
+
+	if res.MemorySwap != "" {
+		if memory <= 0 {
+			return fmt.Errorf("设置 --memory-swap 时需要同时设置 -m 内存上限")
+		}
+		swap, err := ParseMemory(res.MemorySwap)
+		if err != nil {
+			return fmt.Errorf("memory swap 无效 %v", err)
+		}
+		//memory swap 为内存与 swap 的总和
+		if swap != -1 && swap < memory {
+			return fmt.Errorf("memory swap 不能小于内存上限，memory swap 为内存与 swap 的总和")
+		}
+	}
+
+	if res.MemoryReservation != "" {
+		reservation, err := ParseMemory(res.MemoryReservation)
+		if err != nil || reservation < 0 {
+			return fmt.Errorf("memory reservation 无效 %s", res.MemoryReservation)
+		}
+		if memory > 0 && reservation > memory {
+			return fmt.Errorf("memory reservation 不能大于内存上限")
+		}
+	}
+
+	if res.MemorySwappiness != "" {
+		swappiness, err := strconv.Atoi(res.MemorySwappiness)
+		if err != nil || swappiness < 0 || swappiness > 100 {
+			return fmt.Errorf("memory swappiness 无效 %s，取值范围 0-100", res.MemorySwappiness)
+		}
+	}
+
+	if res.KernelMemory != "" {
+		//cgroup v2 没有单独的内核内存限制，内核内存计入 memory.max
+		if IsCgroup2UnifiedMode() {
+			return fmt.Errorf("cgroup v2 不支持 --kernel-memory，内核内存计入 -m 内存上限")
+		}
+		kernelMemory, err := ParseMemory(res.KernelMemory)
+		if err != nil {
+			return fmt.Errorf("kernel memory 无效 %v", err)
+		}
+		if kernelMemory != -1 && kernelMemory < minKernelMemoryLimit {
+			return fmt.Errorf("kernel memory 不能小于 4MB")
+		}
+	}
+
+	if res.OomKillDisable && memory <= 0 {
+		log.Warnf("未设置内存上限时关闭 OOM Killer，可能耗尽宿主机内存")
+	}
+
+	if res.CpuShare != "" {
+		if shares, err := strconv.ParseUint(res.CpuShare, 10, 64); err != nil || shares < 2 {
+			return fmt.Errorf("cpu share 无效 %s", res.CpuShare)
+		}
+	}
+
+	if res.Cpus != "" && (res.CpuQuota != "" || res.CpuPeriod != "") {
+		return fmt.Errorf("--cpus 与 --cpu-quota/--cpu-period 不能同时设置")
+	}
+	quota, period, err := res.CpuQuotaAndPeriod()
+	if err != nil {
+		return err
+	}
+	if res.Cpus != "" && quota > int64(runtime.NumCPU())*period {
+		return fmt.Errorf("--cpus 不能大于宿主机的 CPU 数量 %d", runtime.NumCPU())
+	}
+	if period != 0 && (period < 1000 || period > 1000000) {
+		return fmt.Errorf("cpu period 无效 %d，取值范围 1000-1000000", period)
+	}
+	if quota != 0 && quota != -1 && quota < 1000 {
+		return fmt.Errorf("cpu quota 无效 %d，不能小于 1000", quota)
+	}
+
+	if res.PidsLimit != "" {
+		if _, err := pidsLimitValue(res.PidsLimit); err != nil {
+			return err
+		}
+	}
+
+	if res.BlkioWeight != "" {
+		if weight, err := strconv.ParseUint(res.BlkioWeight, 10, 64); err != nil || weight < 10 || weight > 1000 {
+			return fmt.Errorf("blkio weight 无效 %s，取值范围 10-1000", res.BlkioWeight)
+		}
+	}
+
+	for _, devices := range [][]string{res.DeviceReadBps, res.DeviceWriteBps} {
+		if _, err := ParseThrottleDevices(devices, true); err != nil {
+			return err
+		}
+	}
+	for _, devices := range [][]string{res.DeviceReadIOps, res.DeviceWriteIOps} {
+		if _, err := ParseThrottleDevices(devices, false); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
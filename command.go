@@ -7,8 +7,11 @@ import (
 	"github.com/RedDragonet/rocker/image"
 	"github.com/RedDragonet/rocker/network"
 	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"github.com/RedDragonet/rocker/pkg/stringid"
 	"github.com/urfave/cli/v2"
+	"net"
 	"os"
+	"strings"
 )
 
 func initCommand() *cli.Command {
@@ -25,7 +28,7 @@ func runCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "run",
 		Usage: `创建一个带命名空间的容器`,
-		Flags: []cli.Flag{
+		Flags: append([]cli.Flag{
 			&cli.BoolFlag{
 				Name:  "i",
 				Usage: "开启交互模式",
@@ -38,39 +41,7 @@ func runCommand() *cli.Command {
 				Name:  "d",
 				Usage: "后台运行",
 			},
-			&cli.StringSliceFlag{
-				Name:  "v",
-				Usage: "挂载volume",
-			},
-			&cli.StringSliceFlag{
-				Name:  "p",
-				Usage: "端口映射",
-			},
-			&cli.StringFlag{
-				Name:  "net",
-				Usage: "网卡",
-			},
-			&cli.StringSliceFlag{
-				Name:  "e",
-				Usage: "环境变量",
-			},
-			&cli.StringFlag{
-				Name:  "name",
-				Usage: "指定容器名称",
-			},
-			&cli.StringFlag{
-				Name:  "m",
-				Usage: "内存上限",
-			},
-			&cli.StringFlag{
-				Name:  "cpuset",
-				Usage: "指定Cpu",
-			},
-			&cli.StringFlag{
-				Name:  "cpushare",
-				Usage: "指定Cpu占用率",
-			},
-		},
+		}, containerFlags()...),
 		Action: func(context *cli.Context) error {
 			if context.Args().Len() < 1 {
 				return fmt.Errorf("缺少参数")
@@ -78,30 +49,316 @@ func runCommand() *cli.Command {
 			cmd := context.Args().Get(0)
 			interactive := context.Bool("i")
 			tty := context.Bool("t")
-			volumes := context.StringSlice("v")
-			portMapping := context.StringSlice("p")
 			detach := context.Bool("d")
-			environ := context.StringSlice("e")
 			containerName := context.String("name")
-			net := context.String("net")
 
 			if detach && interactive {
 				return fmt.Errorf("交互模式，与后台运行模式不能共存")
 			}
 
-			resConf := &subsystem.ResourceConfig{
-				MemoryLimit: context.String("m"),
-				CpuSet:      context.String("cpuset"),
-				CpuShare:    context.String("cpushare"),
+			config, err := parseContainerConfig(context)
+			if err != nil {
+				return err
+			}
+			if interactive && !config.RestartPolicy.IsNone() {
+				return fmt.Errorf("交互模式不支持重启策略")
+			}
+
+			//非交互模式由 monitor 进程启动容器并等待退出
+			if !interactive && !isMonitor() {
+				containerID, err := startMonitor("", os.Args[1:])
+				if err != nil {
+					return err
+				}
+				fmt.Println(containerID)
+				return nil
 			}
 
 			log.Infof("命令 %s，参数 interactive=%v, tty=%v", cmd, interactive, tty)
-			Run(interactive, tty, net, volumes, portMapping, environ, context.Args().Slice(), resConf, containerName)
+			Run(interactive, tty, containerName, config)
+			return nil
+		},
+	}
+}
+
+func createCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "create",
+		Usage: `创建容器但不启动，使用 start 启动`,
+		Flags: containerFlags(),
+		Action: func(context *cli.Context) error {
+			if context.Args().Len() < 1 {
+				return fmt.Errorf("缺少参数")
+			}
+
+			config, err := parseContainerConfig(context)
+			if err != nil {
+				return err
+			}
+
+			info, err := createContainer(stringid.GenerateRandomID(), context.String("name"), config)
+			if err != nil {
+				return err
+			}
+			fmt.Println(info.ID)
+			return nil
+		},
+	}
+}
+
+//容器配置参数，run 与 create 共用
+func containerFlags() []cli.Flag {
+	return append([]cli.Flag{
+		&cli.StringSliceFlag{
+			Name:  "v",
+			Usage: "挂载volume",
+		},
+		&cli.StringSliceFlag{
+			Name:  "p",
+			Usage: "端口映射",
+		},
+		&cli.StringSliceFlag{
+			Name:  "net",
+			Usage: "连接的网络，可指定多个，端口映射发布在第一个网络上；或网络模式 host、none、container:<name>",
+		},
+		&cli.StringFlag{
+			Name:  "ip",
+			Usage: "容器在第一个网络中的IP地址",
+		},
+		&cli.StringSliceFlag{
+			Name:  "e",
+			Usage: "环境变量",
+		},
+		&cli.StringFlag{
+			Name:  "name",
+			Usage: "指定容器名称",
+		},
+		&cli.StringFlag{
+			Name:  "restart",
+			Value: container.RestartPolicyNo,
+			Usage: "容器退出后的重启策略 no、always、unless-stopped、on-failure[:max-retries]",
+		},
+		&cli.BoolFlag{
+			Name:  "rm",
+			Usage: "容器退出后自动删除",
+		},
+	}, resourceFlags()...)
+}
+
+//解析容器配置参数，run 与 create 共用
+func parseContainerConfig(context *cli.Context) (container.Config, error) {
+	resConf := parseResourceConfig(context, nil)
+	if err := resConf.Validate(); err != nil {
+		return container.Config{}, err
+	}
+
+	networkMode, networks, err := container.ParseNetworkMode(context.StringSlice("net"))
+	if err != nil {
+		return container.Config{}, err
+	}
+
+	var ip net.IP
+	if context.String("ip") != "" {
+		if ip = net.ParseIP(context.String("ip")); ip == nil {
+			return container.Config{}, fmt.Errorf("错误的IP地址 %s", context.String("ip"))
+		}
+	}
+
+	restartPolicy, err := container.ParseRestartPolicy(context.String("restart"))
+	if err != nil {
+		return container.Config{}, err
+	}
+	autoRemove := context.Bool("rm")
+	if autoRemove && !restartPolicy.IsNone() {
+		return container.Config{}, fmt.Errorf("--rm 与重启策略 %s 不能共存", restartPolicy.Name)
+	}
+
+	return container.Config{
+		Cmd:           context.Args().Slice(),
+		Image:         context.Args().Get(0),
+		Volumes:       context.StringSlice("v"),
+		Env:           context.StringSlice("e"),
+		CGroup:        container.NewCGroupResourceConfig(resConf),
+		PortMapping:   context.StringSlice("p"),
+		Networks:      networks,
+		StaticIP:      ip,
+		NetworkMode:   networkMode,
+		RestartPolicy: restartPolicy,
+		AutoRemove:    autoRemove,
+	}, nil
+}
+
+//资源限制参数，run 与 update 共用
+func resourceFlags() []cli.Flag {
+	return []cli.Flag{
+		&cli.StringFlag{
+			Name:    "m",
+			Aliases: []string{"memory"},
+			Usage:   "内存上限，如 512m",
+		},
+		&cli.StringFlag{
+			Name:  "memory-swap",
+			Usage: "内存与swap的总上限，-1 为不限制swap",
+		},
+		&cli.StringFlag{
+			Name:  "memory-reservation",
+			Usage: "内存软限制",
+		},
+		&cli.StringFlag{
+			Name:  "memory-swappiness",
+			Usage: "swap使用倾向（0-100）",
+		},
+		&cli.StringFlag{
+			Name:  "kernel-memory",
+			Usage: "内核内存上限，如 64m，只支持 cgroup v1",
+		},
+		&cli.BoolFlag{
+			Name:  "oom-kill-disable",
+			Usage: "关闭OOM Killer",
+		},
+		&cli.StringFlag{
+			Name:  "cpuset",
+			Usage: "指定Cpu",
+		},
+		&cli.StringFlag{
+			Name:  "cpushare",
+			Usage: "指定Cpu占用率",
+		},
+		&cli.StringFlag{
+			Name:  "cpus",
+			Usage: "可使用的CPU核数，如 1.5",
+		},
+		&cli.StringFlag{
+			Name:  "cpu-quota",
+			Usage: "CFS调度周期内可使用的CPU时间（微秒），-1 为不限制",
+		},
+		&cli.StringFlag{
+			Name:  "cpu-period",
+			Usage: "CFS调度周期（微秒），默认 100000",
+		},
+		&cli.StringFlag{
+			Name:  "pids-limit",
+			Usage: "进程数上限，-1 为不限制",
+		},
+		&cli.StringFlag{
+			Name:  "blkio-weight",
+			Usage: "块设备IO权重（10-1000）",
+		},
+		&cli.StringSliceFlag{
+			Name:  "device-read-bps",
+			Usage: "限制设备读取速率，如 /dev/sda:1mb",
+		},
+		&cli.StringSliceFlag{
+			Name:  "device-write-bps",
+			Usage: "限制设备写入速率，如 /dev/sda:1mb",
+		},
+		&cli.StringSliceFlag{
+			Name:  "device-read-iops",
+			Usage: "限制设备每秒读取次数，如 /dev/sda:1000",
+		},
+		&cli.StringSliceFlag{
+			Name:  "device-write-iops",
+			Usage: "限制设备每秒写入次数，如 /dev/sda:1000",
+		},
+	}
+}
+
+//根据命令行参数生成资源配置
+//base 不为空时，在 base 的基础上只覆盖显式设置的参数
+func parseResourceConfig(context *cli.Context, base *subsystem.ResourceConfig) *subsystem.ResourceConfig {
+	res := &subsystem.ResourceConfig{}
+	if base != nil {
+		*res = *base
+	}
+
+	stringFlags := map[string]*string{
+		"m":                  &res.MemoryLimit,
+		"memory-swap":        &res.MemorySwap,
+		"memory-reservation": &res.MemoryReservation,
+		"memory-swappiness":  &res.MemorySwappiness,
+		"kernel-memory":      &res.KernelMemory,
+		"cpuset":             &res.CpuSet,
+		"cpushare":           &res.CpuShare,
+		"cpus":               &res.Cpus,
+		"cpu-quota":          &res.CpuQuota,
+		"cpu-period":         &res.CpuPeriod,
+		"pids-limit":         &res.PidsLimit,
+		"blkio-weight":       &res.BlkioWeight,
+	}
+	for name, value := range stringFlags {
+		if base == nil || context.IsSet(name) {
+			*value = context.String(name)
+		}
+	}
+
+	sliceFlags := map[string]*[]string{
+		"device-read-bps":   &res.DeviceReadBps,
+		"device-write-bps":  &res.DeviceWriteBps,
+		"device-read-iops":  &res.DeviceReadIOps,
+		"device-write-iops": &res.DeviceWriteIOps,
+	}
+	for name, value := range sliceFlags {
+		if base == nil || context.IsSet(name) {
+			*value = context.StringSlice(name)
+		}
+	}
+
+	if base == nil || context.IsSet("oom-kill-disable") {
+		res.OomKillDisable = context.Bool("oom-kill-disable")
+	}
+
+	if base != nil {
+		//--cpus 与 --cpu-quota/--cpu-period 互斥，以本次设置的为准
+		if context.IsSet("cpus") {
+			res.CpuQuota = ""
+			res.CpuPeriod = ""
+		} else if context.IsSet("cpu-quota") || context.IsSet("cpu-period") {
+			res.Cpus = ""
+		}
+	}
+	return res
+}
+
+func updateCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "update",
+		Usage: `更新容器的资源限制`,
+		Flags: resourceFlags(),
+		Action: func(context *cli.Context) error {
+			if context.Args().Len() < 1 {
+				return fmt.Errorf("缺少容器名称")
+			}
+			for _, containerName := range context.Args().Slice() {
+				info, err := container.GetContainerInfo(containerName)
+				if err != nil {
+					return err
+				}
+				res := parseResourceConfig(context, info.Config.CGroup.ResourceConfig())
+				if err := UpdateContainer(info, res); err != nil {
+					return err
+				}
+			}
 			return nil
 		},
 	}
 }
 
+func statsCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "stats",
+		Usage: `显示容器的资源使用情况`,
+		Flags: []cli.Flag{
+			&cli.BoolFlag{
+				Name:  "no-stream",
+				Usage: "只显示一次结果",
+			},
+		},
+		Action: func(context *cli.Context) error {
+			return StatsContainers(context.Args().Slice(), context.Bool("no-stream"))
+		},
+	}
+}
+
 func commitCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "commit",
@@ -170,8 +427,159 @@ func stopCommand() *cli.Command {
 	return &cli.Command{
 		Name:  "stop",
 		Usage: `停止容器`,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "time",
+				Aliases: []string{"t"},
+				Value:   10,
+				Usage:   "等待容器退出的秒数，超时后强制杀死",
+			},
+			&cli.StringFlag{
+				Name:    "signal",
+				Aliases: []string{"s"},
+				Usage:   "停止信号，默认使用镜像配置的 StopSignal 或 SIGTERM",
+			},
+		},
+		Action: func(context *cli.Context) error {
+			if context.Args().Len() < 1 {
+				return fmt.Errorf("缺少容器名称")
+			}
+			for _, containerName := range context.Args().Slice() {
+				if err := StopContainer(containerName, context.Int("time"), context.String("signal")); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func startCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "start",
+		Usage: `启动已停止的容器`,
+		Action: func(context *cli.Context) error {
+			if context.Args().Len() < 1 {
+				return fmt.Errorf("缺少容器名称")
+			}
+			for _, containerName := range context.Args().Slice() {
+				if err := StartContainer(containerName); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func restartCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "restart",
+		Usage: `重启容器`,
+		Flags: []cli.Flag{
+			&cli.IntFlag{
+				Name:    "time",
+				Aliases: []string{"t"},
+				Value:   10,
+				Usage:   "等待容器退出的秒数，超时后强制杀死",
+			},
+		},
+		Action: func(context *cli.Context) error {
+			if context.Args().Len() < 1 {
+				return fmt.Errorf("缺少容器名称")
+			}
+			for _, containerName := range context.Args().Slice() {
+				if err := RestartContainer(containerName, context.Int("time")); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func waitCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "wait",
+		Usage: `等待容器退出并打印退出码`,
+		Action: func(context *cli.Context) error {
+			if context.Args().Len() < 1 {
+				return fmt.Errorf("缺少容器名称")
+			}
+			exitCode, err := WaitContainers(context.Args().Slice())
+			if err != nil {
+				return err
+			}
+			//rocker wait 的退出码与容器一致
+			if exitCode != 0 {
+				return cli.Exit("", exitCode)
+			}
+			return nil
+		},
+	}
+}
+
+func killCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "kill",
+		Usage: `向容器发送信号`,
+		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "signal",
+				Aliases: []string{"s"},
+				Value:   "KILL",
+				Usage:   "信号名称或数字，如 SIGHUP、USR1、9",
+			},
+			&cli.BoolFlag{
+				Name:  "all",
+				Usage: "发送给容器内的所有进程，默认只发送给容器主进程",
+			},
+		},
 		Action: func(context *cli.Context) error {
-			StopContainer(context.Args().Get(0))
+			if context.Args().Len() < 1 {
+				return fmt.Errorf("缺少容器名称")
+			}
+			for _, containerName := range context.Args().Slice() {
+				if err := KillContainer(containerName, context.String("signal"), context.Bool("all")); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func pauseCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "pause",
+		Usage: `暂停容器内的所有进程`,
+		Action: func(context *cli.Context) error {
+			if context.Args().Len() < 1 {
+				return fmt.Errorf("缺少容器名称")
+			}
+			for _, containerName := range context.Args().Slice() {
+				if err := PauseContainer(containerName); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+	}
+}
+
+func unpauseCommand() *cli.Command {
+	return &cli.Command{
+		Name:  "unpause",
+		Usage: `恢复被暂停的容器`,
+		Action: func(context *cli.Context) error {
+			if context.Args().Len() < 1 {
+				return fmt.Errorf("缺少容器名称")
+			}
+			for _, containerName := range context.Args().Slice() {
+				if err := UnpauseContainer(containerName); err != nil {
+					return err
+				}
+			}
 			return nil
 		},
 	}
@@ -201,20 +609,48 @@ func networkCommand() *cli.Command {
 						Name:  "driver",
 						Usage: "driver",
 					},
-					&cli.StringFlag{
+					&cli.StringSliceFlag{
 						Name:  "subnet",
-						Usage: "子网IP",
+						Usage: "子网IP，指定 --ipv6 时可同时指定一个 IPv4 与一个 IPv6 子网",
+					},
+					&cli.StringSliceFlag{
+						Name:  "gateway",
+						Usage: "网关地址，默认为子网中第一个可用的地址",
+					},
+					&cli.StringSliceFlag{
+						Name:  "ip-range",
+						Usage: "从子网中的地址范围为容器分配IP，如 10.10.0.128/25",
+					},
+					&cli.BoolFlag{
+						Name:  "ipv6",
+						Usage: "启用 IPv6",
+					},
+					&cli.StringSliceFlag{
+						Name:    "o",
+						Aliases: []string{"opt"},
+						Usage:   "驱动参数 key=value，如 macvlan、ipvlan 的 parent=eth0，bridge 的 com.rocker.bridge.name=br0",
 					},
 				},
 				Action: func(context *cli.Context) error {
 					if context.Args().Len() < 1 {
 						return fmt.Errorf("参数缺失")
 					}
+					options, err := parseNetworkOptions(context.StringSlice("o"))
+					if err != nil {
+						return err
+					}
 					if err := network.Init(); err != nil {
 						return err
 					}
 					//创建网络设备
-					err := network.CreateNetwork(context.String("driver"), context.String("subnet"), context.Args().Get(0))
+					err = network.CreateNetwork(context.Args().Get(0), network.CreateConfig{
+						Driver:   context.String("driver"),
+						Subnets:  context.StringSlice("subnet"),
+						Gateways: context.StringSlice("gateway"),
+						IPRanges: context.StringSlice("ip-range"),
+						IPv6:     context.Bool("ipv6"),
+						Options:  options,
+					})
 					if err != nil {
 						return fmt.Errorf("创建网络失败: %+v", err)
 					}
@@ -233,6 +669,28 @@ func networkCommand() *cli.Command {
 					return nil
 				},
 			},
+			{
+				Name:      "connect",
+				Usage:     "将容器连接到网络",
+				ArgsUsage: "NETWORK CONTAINER",
+				Action: func(context *cli.Context) error {
+					if context.Args().Len() < 2 {
+						return fmt.Errorf("参数缺失")
+					}
+					return ConnectNetwork(context.Args().Get(0), context.Args().Get(1))
+				},
+			},
+			{
+				Name:      "disconnect",
+				Usage:     "断开容器与网络的连接",
+				ArgsUsage: "NETWORK CONTAINER",
+				Action: func(context *cli.Context) error {
+					if context.Args().Len() < 2 {
+						return fmt.Errorf("参数缺失")
+					}
+					return DisconnectNetwork(context.Args().Get(0), context.Args().Get(1))
+				},
+			},
 			{
 				Name:  "remove",
 				Usage: "移除网络设备",
@@ -316,3 +774,16 @@ func imageCommand() *cli.Command {
 		},
 	}
 }
+
+//解析 network create -o 参数，格式 key=value
+func parseNetworkOptions(opts []string) (map[string]string, error) {
+	options := make(map[string]string, len(opts))
+	for _, opt := range opts {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return nil, fmt.Errorf("错误的网络参数 %s，格式为 key=value", opt)
+		}
+		options[kv[0]] = kv[1]
+	}
+	return options, nil
+}
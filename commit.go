@@ -8,6 +8,7 @@ import (
 	"os/exec"
 	"path"
 
+	"github.com/RedDragonet/rocker/cgroup"
 	"github.com/RedDragonet/rocker/container"
 	log "github.com/RedDragonet/rocker/pkg/pidlog"
 )
@@ -50,6 +51,19 @@ func commit(id, output string) error {
 
 	log.Infof("容器打包镜像 %s", id)
 
+	info, err := container.GetContainerInfo(id)
+	if err != nil {
+		return err
+	}
+	//打包期间暂停运行中的容器，保证文件一致，已暂停的容器保持暂停
+	if info.State.Running && !info.State.Paused {
+		cgroupManager := cgroup.NewCgroupManager(info.ID)
+		if err := cgroupManager.Freeze(); err != nil {
+			return fmt.Errorf("容器打包镜像 暂停容器失败 %v", err)
+		}
+		defer cgroupManager.Thaw()
+	}
+
 	//遍历所有层的ID
 	layers, err := getLayers(id)
 	if err != nil {
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RedDragonet/rocker/container"
+	"github.com/RedDragonet/rocker/network"
+)
+
+//将容器连接到网络，运行中的容器立即添加网卡，再次启动时同样连接该网络
+func ConnectNetwork(networkName, containerName string) error {
+	info, err := container.GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if !info.Config.NetworkMode.IsUserDefined() {
+		return fmt.Errorf("容器 %s 网络模式为 %s，不能连接网络", info.Name, info.Config.NetworkMode)
+	}
+	if hasNetwork(info.Config.Networks, networkName) {
+		return fmt.Errorf("容器 %s 已连接网络 %s", info.Name, networkName)
+	}
+	if err := checkNetworks([]string{networkName}); err != nil {
+		return err
+	}
+
+	//重启等待期间容器进程不存在，启动时按配置连接
+	if info.State.Running && info.State.Pid != 0 {
+		if err := network.Connect(networkName, info, len(info.Endpoints) == 0); err != nil {
+			return err
+		}
+	}
+	return container.RecordContainerNetworks(info.ID, append(info.Config.Networks, networkName))
+}
+
+//断开容器与网络的连接，删除容器中的网卡并释放IP地址
+func DisconnectNetwork(networkName, containerName string) error {
+	info, err := container.GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if !hasNetwork(info.Config.Networks, networkName) {
+		return fmt.Errorf("容器 %s 未连接网络 %s", info.Name, networkName)
+	}
+	if err := network.Init(); err != nil {
+		return err
+	}
+
+	if info.Endpoint(networkName) != nil {
+		if err := network.Disconnect(networkName, info); err != nil {
+			return err
+		}
+	}
+
+	networks := make([]string, 0, len(info.Config.Networks))
+	for _, net := range info.Config.Networks {
+		if net != networkName {
+			networks = append(networks, net)
+		}
+	}
+	return container.RecordContainerNetworks(info.ID, networks)
+}
@@ -1,8 +1,11 @@
 package container
 
 import (
+	"fmt"
 	"net"
 	"time"
+
+	"github.com/RedDragonet/rocker/cgroup/subsystem"
 )
 
 //https://github.com/moby/moby/blob/46cdcd206c56172b95ba5c77b827a722dab426c5/container/state.go#L17
@@ -14,6 +17,32 @@ type ContainerInfo struct {
 	Args    []interface{} `json:"Args"`
 	Config  Config        `json:"Config"`
 	Name    string        `json:"Name"`
+	//按重启策略自动重启的次数，手动启动时清零
+	RestartCount int `json:"RestartCount"`
+	//容器在各网络中的端点
+	Endpoints []EndpointInfo `json:"Endpoints"`
+}
+
+//容器在网络中的端点，连接网络时记录，断开网络或删除容器时据此释放IP地址与 veth
+type EndpointInfo struct {
+	ID          string   `json:"ID"`
+	Network     string   `json:"Network"`
+	IPAddress   net.IP   `json:"IPAddress"`
+	IPv6Address net.IP   `json:"IPv6Address"`
+	MacAddress  string   `json:"MacAddress"`
+	VethName    string   `json:"VethName"`
+	PeerName    string   `json:"PeerName"`
+	PortMapping []string `json:"PortMapping"`
+}
+
+//容器在网络中的端点，未连接时返回 nil
+func (c *ContainerInfo) Endpoint(networkName string) *EndpointInfo {
+	for i := range c.Endpoints {
+		if c.Endpoints[i].Network == networkName {
+			return &c.Endpoints[i]
+		}
+	}
+	return nil
 }
 
 type State struct {
@@ -27,21 +56,100 @@ type State struct {
 	ExitCode          int       `json:"ExitCode"`
 	StartedAt         time.Time `json:"StartedAt"`
 	FinishedAt        time.Time `json:"FinishedAt"`
+	//通过 stop 手动停止，不再按重启策略重启
+	ManuallyStopped bool `json:"ManuallyStopped"`
 }
 
 type Config struct {
 	Cmd         []string             `json:"Cmd"`
 	Image       string               `json:"Image"`
 	Volumes     []string             `json:"Volumes"`
+	Env         []string             `json:"Env"`
 	CGroup      CGroupResourceConfig `json:"CGroup"`
 	PortMapping []string             `json:"portmapping"`
-	IP          net.IP               `json:"IP"`
+	//容器连接的网络，端口映射发布在第一个网络上
+	Networks []string `json:"Networks"`
+	//--ip 指定容器在第一个网络中的地址
+	StaticIP net.IP `json:"StaticIP"`
+	//旧版本记录的容器IP地址，没有 Endpoints 的旧容器删除时据此释放IP地址与端口映射
+	LegacyIP net.IP `json:"IP,omitempty"`
+	//网络模式 host、none、container:<ID>，设置后不连接网络
+	NetworkMode NetworkMode `json:"NetworkMode"`
+	//容器退出后的重启策略
+	RestartPolicy RestartPolicy `json:"RestartPolicy"`
+	//容器退出后自动删除
+	AutoRemove bool `json:"AutoRemove"`
 }
 
 type CGroupResourceConfig struct {
-	MemoryLimit string `json:"MemoryLimit"`
-	CpuShare    string `json:"CpuShare"`
-	CpuSet      string `json:"CpuSet"`
+	MemoryLimit       string `json:"MemoryLimit"`
+	MemorySwap        string `json:"MemorySwap"`
+	MemoryReservation string `json:"MemoryReservation"`
+	MemorySwappiness  string `json:"MemorySwappiness"`
+	KernelMemory      string `json:"KernelMemory"`
+	OomKillDisable    bool   `json:"OomKillDisable"`
+
+	CpuShare  string `json:"CpuShare"`
+	Cpus      string `json:"Cpus"`
+	CpuQuota  string `json:"CpuQuota"`
+	CpuPeriod string `json:"CpuPeriod"`
+	CpuSet    string `json:"CpuSet"`
+	PidsLimit string `json:"PidsLimit"`
+
+	BlkioWeight     string   `json:"BlkioWeight"`
+	DeviceReadBps   []string `json:"DeviceReadBps"`
+	DeviceWriteBps  []string `json:"DeviceWriteBps"`
+	DeviceReadIOps  []string `json:"DeviceReadIOps"`
+	DeviceWriteIOps []string `json:"DeviceWriteIOps"`
+}
+
+func NewCGroupResourceConfig(res *subsystem.ResourceConfig) CGroupResourceConfig {
+	return CGroupResourceConfig{
+		MemoryLimit:       res.MemoryLimit,
+		MemorySwap:        res.MemorySwap,
+		MemoryReservation: res.MemoryReservation,
+		MemorySwappiness:  res.MemorySwappiness,
+		KernelMemory:      res.KernelMemory,
+		OomKillDisable:    res.OomKillDisable,
+
+		CpuShare:  res.CpuShare,
+		Cpus:      res.Cpus,
+		CpuQuota:  res.CpuQuota,
+		CpuPeriod: res.CpuPeriod,
+		CpuSet:    res.CpuSet,
+		PidsLimit: res.PidsLimit,
+
+		BlkioWeight:     res.BlkioWeight,
+		DeviceReadBps:   res.DeviceReadBps,
+		DeviceWriteBps:  res.DeviceWriteBps,
+		DeviceReadIOps:  res.DeviceReadIOps,
+		DeviceWriteIOps: res.DeviceWriteIOps,
+	}
+}
+
+//转换为 cgroup 资源配置，用于重新设置容器的 cgroup
+func (c *CGroupResourceConfig) ResourceConfig() *subsystem.ResourceConfig {
+	return &subsystem.ResourceConfig{
+		MemoryLimit:       c.MemoryLimit,
+		MemorySwap:        c.MemorySwap,
+		MemoryReservation: c.MemoryReservation,
+		MemorySwappiness:  c.MemorySwappiness,
+		KernelMemory:      c.KernelMemory,
+		OomKillDisable:    c.OomKillDisable,
+
+		CpuShare:  c.CpuShare,
+		Cpus:      c.Cpus,
+		CpuQuota:  c.CpuQuota,
+		CpuPeriod: c.CpuPeriod,
+		CpuSet:    c.CpuSet,
+		PidsLimit: c.PidsLimit,
+
+		BlkioWeight:     c.BlkioWeight,
+		DeviceReadBps:   c.DeviceReadBps,
+		DeviceWriteBps:  c.DeviceWriteBps,
+		DeviceReadIOps:  c.DeviceReadIOps,
+		DeviceWriteIOps: c.DeviceWriteIOps,
+	}
 }
 
 func (s *State) String() string {
@@ -71,5 +179,8 @@ func (s *State) String() string {
 		return ""
 	}
 
-	return "Exited"
+	if s.OOMKilled {
+		return fmt.Sprintf("Exited (%d) OOMKilled", s.ExitCode)
+	}
+	return fmt.Sprintf("Exited (%d)", s.ExitCode)
 }
@@ -228,7 +228,8 @@ func NewParentProcess(interactive, tty bool, image string, volumeSlice, environS
 			return nil, nil
 		}
 		stdLogFilePath := path.Join(dirURL, ContainerLogFile)
-		stdLogFile, err := os.Create(stdLogFilePath)
+		//再次启动容器时追加日志
+		stdLogFile, err := os.OpenFile(stdLogFilePath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
 		if err != nil {
 			log.Errorf("NewParentProcess create file %s error %v", stdLogFilePath, err)
 			return nil, nil
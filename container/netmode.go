@@ -0,0 +1,66 @@
+package container
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	NetworkModeHost      = "host"
+	NetworkModeNone      = "none"
+	NetworkModeContainer = "container"
+)
+
+//容器的网络模式，为空时容器使用独立的 network namespace，并连接 --net 指定的网络
+//host 使用宿主机的 network namespace
+//none 使用独立的 network namespace，只有回环网卡
+//container:<ID> 加入其他容器的 network namespace
+type NetworkMode string
+
+//解析 --net 参数，host、none、container:<name> 不能与其他网络同时指定
+func ParseNetworkMode(networks []string) (NetworkMode, []string, error) {
+	for _, net := range networks {
+		mode := NetworkMode(net)
+		if !mode.IsHost() && !mode.IsNone() && !mode.IsContainer() {
+			continue
+		}
+		if len(networks) > 1 {
+			return "", nil, fmt.Errorf("网络模式 %s 不能与其他网络同时使用", net)
+		}
+		if mode.IsContainer() && mode.ConnectedContainer() == "" {
+			return "", nil, fmt.Errorf("错误的网络模式 %s，格式为 container:<name>", net)
+		}
+		return mode, nil, nil
+	}
+	return "", networks, nil
+}
+
+func (n NetworkMode) IsHost() bool {
+	return n == NetworkModeHost
+}
+
+func (n NetworkMode) IsNone() bool {
+	return n == NetworkModeNone
+}
+
+func (n NetworkMode) IsContainer() bool {
+	return strings.HasPrefix(string(n), NetworkModeContainer+":")
+}
+
+//container:<name> 模式下加入的容器
+func (n NetworkMode) ConnectedContainer() string {
+	if !n.IsContainer() {
+		return ""
+	}
+	return strings.TrimPrefix(string(n), NetworkModeContainer+":")
+}
+
+//是否使用独立的 network namespace
+func (n NetworkMode) IsPrivate() bool {
+	return !n.IsHost() && !n.IsContainer()
+}
+
+//是否可以连接网络
+func (n NetworkMode) IsUserDefined() bool {
+	return n == ""
+}
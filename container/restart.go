@@ -0,0 +1,70 @@
+package container
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	RestartPolicyNo            = "no"
+	RestartPolicyAlways        = "always"
+	RestartPolicyOnFailure     = "on-failure"
+	RestartPolicyUnlessStopped = "unless-stopped"
+)
+
+//容器退出后的重启策略
+//没有常驻的 daemon，unless-stopped 与 always 行为一致，手动 stop 后都不再重启
+type RestartPolicy struct {
+	Name string `json:"Name"`
+	//on-failure 的最大重启次数，0 表示不限制
+	MaximumRetryCount int `json:"MaximumRetryCount"`
+}
+
+//解析 --restart 参数，格式 no、always、unless-stopped、on-failure[:max-retries]
+func ParseRestartPolicy(policy string) (RestartPolicy, error) {
+	if policy == "" {
+		return RestartPolicy{Name: RestartPolicyNo}, nil
+	}
+
+	parts := strings.SplitN(policy, ":", 2)
+	p := RestartPolicy{Name: parts[0]}
+	switch p.Name {
+	case RestartPolicyNo, RestartPolicyAlways, RestartPolicyUnlessStopped:
+		if len(parts) == 2 {
+			return p, fmt.Errorf("重启策略 %s 不支持设置最大重启次数", p.Name)
+		}
+	case RestartPolicyOnFailure:
+		if len(parts) == 2 {
+			count, err := strconv.Atoi(parts[1])
+			if err != nil || count < 0 {
+				return p, fmt.Errorf("错误的最大重启次数 %s", parts[1])
+			}
+			p.MaximumRetryCount = count
+		}
+	default:
+		return p, fmt.Errorf("错误的重启策略 %s，支持 no、always、unless-stopped、on-failure[:max-retries]", policy)
+	}
+	return p, nil
+}
+
+func (rp RestartPolicy) IsNone() bool {
+	return rp.Name == "" || rp.Name == RestartPolicyNo
+}
+
+//容器退出后是否需要重启
+func (rp RestartPolicy) ShouldRestart(exitCode, restartCount int, manuallyStopped bool) bool {
+	if manuallyStopped {
+		return false
+	}
+	switch rp.Name {
+	case RestartPolicyAlways, RestartPolicyUnlessStopped:
+		return true
+	case RestartPolicyOnFailure:
+		if exitCode == 0 {
+			return false
+		}
+		return rp.MaximumRetryCount == 0 || restartCount < rp.MaximumRetryCount
+	}
+	return false
+}
@@ -0,0 +1,181 @@
+package container
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/RedDragonet/rocker/cgroup"
+	image2 "github.com/RedDragonet/rocker/image"
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"github.com/RedDragonet/rocker/pkg/signal"
+)
+
+//停止容器，先发送停止信号，超时后向 cgroup 中的所有进程发送 SIGKILL
+//sig 为 0 时使用镜像配置的 StopSignal，默认 SIGTERM
+func StopContainer(containerName string, timeout time.Duration, sig syscall.Signal) error {
+	info, err := GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if !info.State.Running {
+		log.Infof("容器 %s 未运行", info.Name)
+		return nil
+	}
+
+	//标记为手动停止，monitor 不再按重启策略重启容器
+	if err := RecordContainerManuallyStopped(info.ID); err != nil {
+		return err
+	}
+	//等待重启期间没有容器进程，直接记录为已停止
+	if info.State.Restarting {
+		log.Infof("StopContainer %s 取消重启", info.Name)
+		return RecordContainerExit(info.ID, info.State.ExitCode, info.State.OOMKilled)
+	}
+
+	if sig == 0 {
+		sig = getStopSignal(info.Config.Image)
+	}
+
+	cgroupManager := cgroup.NewCgroupManager(info.ID)
+	//被冻结的进程无法处理信号，先恢复容器
+	if info.State.Paused {
+		if err := cgroupManager.Thaw(); err != nil {
+			log.Errorf("StopContainer %s 恢复容器失败 %v", info.Name, err)
+			return fmt.Errorf("StopContainer %s 恢复容器失败 %v", info.Name, err)
+		}
+		if err := RecordContainerPaused(info.ID, false); err != nil {
+			return err
+		}
+	}
+
+	pid := info.State.Pid
+	log.Infof("StopContainer %s 发送信号 %d 到 pid %d", info.Name, sig, pid)
+	if err := syscall.Kill(pid, sig); err != nil && err != syscall.ESRCH {
+		log.Errorf("syscall kill %s pid %d error %v", info.Name, pid, err)
+		return fmt.Errorf("syscall kill %s pid %d error %v", info.Name, pid, err)
+	}
+
+	if !waitProcessExit(pid, timeout) {
+		log.Infof("StopContainer %s %v 内未退出，发送 SIGKILL", info.Name, timeout)
+		sig = syscall.SIGKILL
+		killCgroupProcesses(cgroupManager, pid, sig)
+		if !waitProcessExit(pid, 10*time.Second) {
+			log.Errorf("StopContainer %s 失败，pid %d 未退出", info.Name, pid)
+			return fmt.Errorf("StopContainer %s 失败，pid %d 未退出", info.Name, pid)
+		}
+	}
+
+	//由等待容器的进程记录退出状态，该进程不存在时由当前进程记录
+	if waitExitRecorded(info.ID, time.Second) {
+		return nil
+	}
+	cgroupManager.Destroy()
+	UnmountContainer(info.ID, info.Config.Volumes)
+	return RecordContainerExit(info.ID, 128+int(sig), false)
+}
+
+//镜像配置的停止信号
+func getStopSignal(imageName string) syscall.Signal {
+	image2.Init()
+	if i := image2.Get(imageName); i != nil {
+		if r, err := i.GetRuntime(); err == nil && r.Config.StopSignal != "" {
+			if sig, err := signal.ParseSignal(r.Config.StopSignal); err == nil {
+				return sig
+			}
+			log.Warnf("镜像 %s 的 StopSignal %s 无效", imageName, r.Config.StopSignal)
+		}
+	}
+	return syscall.SIGTERM
+}
+
+//向容器发送信号，all 为 true 时发送给 cgroup 中的所有进程
+func KillContainer(containerName string, sig syscall.Signal, all bool) error {
+	info, err := GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if !info.State.Running {
+		return fmt.Errorf("容器 %s 未运行", info.Name)
+	}
+	if info.State.Restarting {
+		return fmt.Errorf("容器 %s 正在等待重启", info.Name)
+	}
+	if info.State.Paused {
+		return fmt.Errorf("容器 %s 已暂停，请先恢复容器", info.Name)
+	}
+
+	pid := info.State.Pid
+	if all {
+		log.Infof("KillContainer %s 发送信号 %d 到所有进程", info.Name, sig)
+		killCgroupProcesses(cgroup.NewCgroupManager(info.ID), pid, sig)
+		return nil
+	}
+
+	log.Infof("KillContainer %s 发送信号 %d 到 pid %d", info.Name, sig, pid)
+	if err := syscall.Kill(pid, sig); err != nil {
+		log.Errorf("syscall kill %s pid %d error %v", info.Name, pid, err)
+		return fmt.Errorf("syscall kill %s pid %d error %v", info.Name, pid, err)
+	}
+	return nil
+}
+
+//向 cgroup 中的所有进程发送信号，获取失败时只发送给容器主进程
+func killCgroupProcesses(cgroupManager *cgroup.CgroupManager, pid int, sig syscall.Signal) {
+	pids, err := cgroupManager.GetPids()
+	if err != nil {
+		log.Warnf("获取 cgroup 进程失败 %v", err)
+		pids = []int{pid}
+	}
+	for _, p := range pids {
+		if err := syscall.Kill(p, sig); err != nil && err != syscall.ESRCH {
+			log.Warnf("syscall kill pid %d error %v", p, err)
+		}
+	}
+}
+
+//等待进程退出，超时返回 false
+func waitProcessExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if processExited(pid) {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+//进程不存在或已成为僵尸进程
+func processExited(pid int) bool {
+	content, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	//1234 (sleep) S 1233 ...
+	stat := string(content)
+	fields := strings.Fields(stat[strings.LastIndex(stat, ")")+1:])
+	return len(fields) > 0 && fields[0] == "Z"
+}
+
+//等待退出状态被记录，容器配置已被删除时同样视为已记录
+func waitExitRecorded(containerId string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		if !containerInfoExists(containerId) {
+			return true
+		}
+		info, err := readContainerInfo(containerId)
+		if err != nil || !info.State.Running {
+			return true
+		}
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
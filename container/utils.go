@@ -1,26 +1,27 @@
 package container
 
 import (
-	"bufio"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
-	"net"
 	"os"
-	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
-	"syscall"
 	"time"
 
+	"github.com/RedDragonet/rocker/cgroup"
 	"github.com/RedDragonet/rocker/cgroup/subsystem"
 	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"golang.org/x/sys/unix"
 )
 
 func GetContainerInfo(containerName string) (*ContainerInfo, error) {
-	containerName = fixContainerName(containerName)
+	return readContainerInfo(fixContainerName(containerName))
+}
 
+//读取容器配置，容器不存在时返回错误
+func readContainerInfo(containerName string) (*ContainerInfo, error) {
 	configFileDir := path.Join(DefaultInfoLocation, containerName)
 	configFileDir = path.Join(configFileDir, ConfigName)
 	content, err := ioutil.ReadFile(configFileDir)
@@ -36,26 +37,10 @@ func GetContainerInfo(containerName string) (*ContainerInfo, error) {
 	return &containerInfo, nil
 }
 
-func StopContainer(containerName string) error {
-	info, err := GetContainerInfo(containerName)
-	if err != nil {
-		return err
-	}
-
-	if err != nil {
-		log.Errorf("GetContainerPidByName %s error %v", containerName, err)
-		return fmt.Errorf("GetContainerPidByName %s error %v", containerName, err)
-	}
-
-	pid := info.State.Pid
-	//KILL 进程
-	if err := syscall.Kill(pid, syscall.SIGTERM); err != nil {
-		log.Errorf("syscall kill %s pid %d error %v", containerName, pid, err)
-		return fmt.Errorf("syscall kill %s pid %d error %v", containerName, pid, err)
-	}
-
-	info.State.Paused = true
-	return save(info)
+//容器配置文件是否存在
+func containerInfoExists(containerId string) bool {
+	_, err := os.Stat(path.Join(DefaultInfoLocation, containerId, ConfigName))
+	return err == nil
 }
 
 func RemoveContainer(containerName string) error {
@@ -64,8 +49,8 @@ func RemoveContainer(containerName string) error {
 		return err
 	}
 
-	if info.State.Running && !info.State.Paused {
-		return fmt.Errorf("请先暂停容器")
+	if info.State.Running {
+		return fmt.Errorf("请先停止容器")
 	}
 
 	CleanUp(info.ID, info.Config.Volumes)
@@ -77,18 +62,7 @@ func RemoveContainer(containerName string) error {
 func fixContainerName(containerName string) string {
 	dirUrl := path.Join(DefaultInfoLocation, containerName)
 	if _, err := os.Stat(dirUrl); os.IsNotExist(err) {
-		matched := make([]string, 0)
-
-		filepath.Walk(DefaultInfoLocation, func(nwPath string, info os.FileInfo, err error) error {
-			if err != nil || !info.IsDir() {
-				return nil
-			}
-			nwPathBase := path.Base(nwPath)
-			if strings.HasPrefix(nwPathBase, containerName) {
-				matched = append(matched, nwPathBase)
-			}
-			return nil
-		})
+		matched := matchContainerIds(containerName)
 
 		if len(matched) == 1 {
 			return matched[0]
@@ -108,45 +82,218 @@ func fixContainerName(containerName string) string {
 	return containerName
 }
 
-func RecordContainerInfo(containerPID int, commandArray []string, containerName, id string, volumeSlice, portMapping []string, res *subsystem.ResourceConfig) (string, error) {
+//前缀匹配容器ID
+func matchContainerIds(prefix string) []string {
+	matched := make([]string, 0)
+	filepath.Walk(DefaultInfoLocation, func(nwPath string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return nil
+		}
+		nwPathBase := path.Base(nwPath)
+		if strings.HasPrefix(nwPathBase, prefix) {
+			matched = append(matched, nwPathBase)
+		}
+		return nil
+	})
+	return matched
+}
+
+//记录容器配置，容器处于 Created 状态，启动时由 RecordContainerStart 更新
+func RecordContainerInfo(id, containerName string, config Config) (*ContainerInfo, error) {
 	containerInfo := &ContainerInfo{
-		ID: id,
-		State: State{
-			Pid:     containerPID,
-			Running: true,
-		},
-		Config: Config{
-			Cmd:     commandArray,
-			Image:   "",
-			Volumes: volumeSlice,
-			CGroup: CGroupResourceConfig{
-				MemoryLimit: res.MemoryLimit,
-				CpuShare:    res.CpuShare,
-				CpuSet:      res.CpuSet,
-			},
-			PortMapping: portMapping,
-		},
+		ID:      id,
+		Config:  config,
 		Created: time.Now(),
 		Name:    containerName,
 	}
 
 	err := save(containerInfo)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	return containerName, nil
+	return containerInfo, nil
 }
 
-func RecordContainerIP(containerId string, ip net.IP) error {
-	info, err := GetContainerInfo(containerId)
+//记录容器启动，清除上一次的退出状态
+func RecordContainerStart(containerId string, containerPID int) (*ContainerInfo, error) {
+	info, err := updateContainerInfo(containerId, func(info *ContainerInfo) {
+		info.State.Running = true
+		info.State.Paused = false
+		info.State.Restarting = false
+		info.State.ManuallyStopped = false
+		info.State.Pid = containerPID
+		info.State.StartedAt = time.Now()
+		info.State.FinishedAt = time.Time{}
+		info.State.ExitCode = 0
+		info.State.OOMKilled = false
+	})
+	if err != nil {
+		log.Errorf("RecordContainerStart 更新容器 %s 失败 %v", containerId, err)
+		return nil, fmt.Errorf("RecordContainerStart 更新容器 %s 失败 %v", containerId, err)
+	}
+	return info, nil
+}
+
+//记录容器在网络中的端点，已连接该网络时替换原有记录
+func RecordContainerEndpoint(containerId string, endpoint EndpointInfo) error {
+	_, err := updateContainerInfo(containerId, func(info *ContainerInfo) {
+		if ep := info.Endpoint(endpoint.Network); ep != nil {
+			*ep = endpoint
+		} else {
+			info.Endpoints = append(info.Endpoints, endpoint)
+		}
+	})
+	if err != nil {
+		log.Errorf("RecordContainerEndpoint 更新容器 %s 失败 %v", containerId, err)
+		return fmt.Errorf("RecordContainerEndpoint 更新容器 %s 失败 %v", containerId, err)
+	}
+	return nil
+}
+
+//删除容器在网络中的端点记录
+func RemoveContainerEndpoint(containerId string, networkName string) error {
+	_, err := updateContainerInfo(containerId, func(info *ContainerInfo) {
+		endpoints := make([]EndpointInfo, 0, len(info.Endpoints))
+		for _, ep := range info.Endpoints {
+			if ep.Network != networkName {
+				endpoints = append(endpoints, ep)
+			}
+		}
+		info.Endpoints = endpoints
+	})
+	if err != nil {
+		log.Errorf("RemoveContainerEndpoint 更新容器 %s 失败 %v", containerId, err)
+		return fmt.Errorf("RemoveContainerEndpoint 更新容器 %s 失败 %v", containerId, err)
+	}
+	return nil
+}
+
+//记录容器连接的网络，再次启动时连接这些网络
+func RecordContainerNetworks(containerId string, networks []string) error {
+	_, err := updateContainerInfo(containerId, func(info *ContainerInfo) {
+		info.Config.Networks = networks
+	})
+	if err != nil {
+		log.Errorf("RecordContainerNetworks 更新容器 %s 失败 %v", containerId, err)
+		return fmt.Errorf("RecordContainerNetworks 更新容器 %s 失败 %v", containerId, err)
+	}
+	return nil
+}
+
+func RecordContainerResource(containerId string, res *subsystem.ResourceConfig) error {
+	_, err := updateContainerInfo(containerId, func(info *ContainerInfo) {
+		info.Config.CGroup = NewCGroupResourceConfig(res)
+	})
+	if err != nil {
+		log.Errorf("RecordContainerResource 更新容器 %s 失败 %v", containerId, err)
+		return fmt.Errorf("RecordContainerResource 更新容器 %s 失败 %v", containerId, err)
+	}
+	return nil
+}
+
+func RecordContainerPaused(containerId string, paused bool) error {
+	_, err := updateContainerInfo(containerId, func(info *ContainerInfo) {
+		info.State.Paused = paused
+	})
+	if err != nil {
+		log.Errorf("RecordContainerPaused 更新容器 %s 失败 %v", containerId, err)
+		return fmt.Errorf("RecordContainerPaused 更新容器 %s 失败 %v", containerId, err)
+	}
+	return nil
+}
+
+//记录容器退出状态
+func RecordContainerExit(containerId string, exitCode int, oomKilled bool) error {
+	_, err := updateContainerInfo(containerId, func(info *ContainerInfo) {
+		info.State.Running = false
+		info.State.Paused = false
+		info.State.Restarting = false
+		info.State.Pid = 0
+		info.State.ExitCode = exitCode
+		info.State.OOMKilled = oomKilled
+		info.State.FinishedAt = time.Now()
+	})
 	if err != nil {
-		log.Errorf("RecordContainerIP 容器不存在 %s", containerId)
-		return fmt.Errorf("RecordContainerIP 容器不存在 %s", containerId)
+		log.Errorf("RecordContainerExit 更新容器 %s 失败 %v", containerId, err)
+		return fmt.Errorf("RecordContainerExit 更新容器 %s 失败 %v", containerId, err)
 	}
+	return nil
+}
+
+//记录容器等待重启，等待期间没有容器进程
+func RecordContainerRestarting(containerId string) (*ContainerInfo, error) {
+	info, err := updateContainerInfo(containerId, func(info *ContainerInfo) {
+		info.State.Running = true
+		info.State.Restarting = true
+		info.State.Paused = false
+		info.State.Pid = 0
+		info.RestartCount++
+	})
+	if err != nil {
+		log.Errorf("RecordContainerRestarting 更新容器 %s 失败 %v", containerId, err)
+		return nil, fmt.Errorf("RecordContainerRestarting 更新容器 %s 失败 %v", containerId, err)
+	}
+	return info, nil
+}
+
+//记录容器被手动停止，不再按重启策略重启
+func RecordContainerManuallyStopped(containerId string) error {
+	_, err := updateContainerInfo(containerId, func(info *ContainerInfo) {
+		info.State.ManuallyStopped = true
+	})
+	if err != nil {
+		log.Errorf("RecordContainerManuallyStopped 更新容器 %s 失败 %v", containerId, err)
+		return fmt.Errorf("RecordContainerManuallyStopped 更新容器 %s 失败 %v", containerId, err)
+	}
+	return nil
+}
 
-	info.Config.IP = ip
-	return save(info)
+//手动启动时清零重启次数
+func ResetContainerRestartCount(containerId string) error {
+	_, err := updateContainerInfo(containerId, func(info *ContainerInfo) {
+		info.RestartCount = 0
+	})
+	if err != nil {
+		log.Errorf("ResetContainerRestartCount 更新容器 %s 失败 %v", containerId, err)
+		return fmt.Errorf("ResetContainerRestartCount 更新容器 %s 失败 %v", containerId, err)
+	}
+	return nil
+}
+
+//持有容器的文件锁读取配置并执行 fn，再保存修改后的配置，避免并发的读-改-写相互覆盖
+func updateContainerInfo(containerName string, fn func(info *ContainerInfo)) (*ContainerInfo, error) {
+	containerId := fixContainerName(containerName)
+	unlock, err := lockContainerInfo(containerId)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	info, err := readContainerInfo(containerId)
+	if err != nil {
+		return nil, err
+	}
+	fn(info)
+	return info, save(info)
+}
+
+//获取容器的文件锁，锁文件位于容器目录下，容器被删除后无法再获取
+func lockContainerInfo(containerId string) (func(), error) {
+	lockFileName := path.Join(DefaultInfoLocation, containerId, ConfigName+".lock")
+	lockFile, err := os.OpenFile(lockFileName, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if err := unix.Flock(int(lockFile.Fd()), unix.LOCK_EX); err != nil {
+		lockFile.Close()
+		log.Errorf("获取容器文件锁失败 %s %v", lockFileName, err)
+		return nil, fmt.Errorf("获取容器文件锁失败 %s %v", lockFileName, err)
+	}
+	return func() {
+		unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
+		lockFile.Close()
+	}, nil
 }
 
 func save(containerInfo *ContainerInfo) error {
@@ -166,15 +313,15 @@ func save(containerInfo *ContainerInfo) error {
 		}
 	}
 
+	//先写入临时文件再重命名，保证 config.json 不会被写坏
 	fileName := path.Join(dirUrl, ConfigName)
-	file, err := os.Create(fileName)
-	defer file.Close()
-	if err != nil {
-		log.Errorf("Create file %s error %v", fileName, err)
+	tmpFileName := fileName + ".tmp"
+	if err := ioutil.WriteFile(tmpFileName, []byte(jsonStr), 0644); err != nil {
+		log.Errorf("Write file %s error %v", tmpFileName, err)
 		return err
 	}
-	if _, err := file.WriteString(jsonStr); err != nil {
-		log.Errorf("File write string error %v", err)
+	if err := os.Rename(tmpFileName, fileName); err != nil {
+		log.Errorf("Rename file %s error %v", tmpFileName, err)
 		return err
 	}
 
@@ -182,6 +329,10 @@ func save(containerInfo *ContainerInfo) error {
 }
 
 func DeleteContainerInfo(containerId string) error {
+	//等待进行中的配置更新完成，避免更新时重新创建已删除的容器目录
+	if unlock, err := lockContainerInfo(containerId); err == nil {
+		defer unlock()
+	}
 	dirURL := path.Join(DefaultInfoLocation, containerId)
 	if err := os.RemoveAll(dirURL); err != nil {
 		log.Errorf("Remove dir %s error %v", dirURL, err)
@@ -190,62 +341,6 @@ func DeleteContainerInfo(containerId string) error {
 	return nil
 }
 
-func CleanPortMapping(cinfo *ContainerInfo) error {
-	ip := cinfo.Config.IP
-	if ip == nil {
-		return fmt.Errorf("CleanPortMapping 未配置IP地址")
-	}
-
-	log.Infof("CleanPortMapping IP地址 %s", ip.String())
-	if err := cleanPortMapping("PREROUTING", ip.String()); err != nil {
-		return err
-	}
-
-	if err := cleanPortMapping("OUTPUT", ip.String()); err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func cleanPortMapping(table, ip string) error {
-	iptablesCmd := fmt.Sprintf("-t nat -nvL %s --line-number", table)
-	cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
-	//err := cmd.Run()
-	output, err := cmd.StdoutPipe()
-	if err != nil {
-		return err
-	}
-	defer output.Close()
-
-	if err := cmd.Start(); err != nil {
-		return err
-	}
-
-	rd := bufio.NewReaderSize(output, 256)
-
-	/*
-		num   pkts bytes target     prot opt in     out     source               destination
-		1        2   120 DNAT       tcp  --  *      lo      0.0.0.0/0            0.0.0.0/0            tcp dpt:80 to:192.168.10.2:80
-	*/
-	line, err := rd.ReadString('\n')
-	for err == nil && line != "" {
-		//存在对应ip配置
-		if strings.Contains(line, ip) {
-			number := strings.TrimSpace(line[:4])
-			//删除对应配置
-			iptablesCmd := fmt.Sprintf("-t nat -D %s %s", table, number)
-			cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
-			err := cmd.Run()
-			if err != nil {
-				return err
-			}
-		}
-		line, err = rd.ReadString('\n')
-	}
-	return cmd.Wait()
-}
-
 func CleanUp(containerId string, volumes []string) {
 	info, err := GetContainerInfo(containerId)
 	if err != nil {
@@ -258,8 +353,8 @@ func CleanUp(containerId string, volumes []string) {
 	}
 
 	DeleteContainerInfo(containerId)
+	cgroup.NewCgroupManager(containerId).Destroy()
 	UnMountVolumeSlice(containerId, volumes)
 	DelDefaultDevice(containerId)
 	DelWorkSpace(containerId)
-	CleanPortMapping(info)
 }
@@ -0,0 +1,45 @@
+package container
+
+import (
+	"sync"
+	"testing"
+)
+
+//并发更新同一个容器时，每次修改都应该被保留
+func TestUpdateContainerInfoConcurrent(t *testing.T) {
+	setupInfoLocation(t)
+	info, err := RecordContainerInfo("concurrent", "concurrent", Config{})
+	if err != nil {
+		t.Fatalf("RecordContainerInfo() error = %v", err)
+	}
+
+	const n = 50
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := RecordContainerRestarting(info.ID); err != nil {
+				t.Errorf("RecordContainerRestarting() error = %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if err := RecordContainerManuallyStopped(info.ID); err != nil {
+				t.Errorf("RecordContainerManuallyStopped() error = %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	got, err := readContainerInfo(info.ID)
+	if err != nil {
+		t.Fatalf("readContainerInfo() error = %v", err)
+	}
+	if got.RestartCount != n {
+		t.Errorf("RestartCount = %d, want %d", got.RestartCount, n)
+	}
+	if !got.State.ManuallyStopped {
+		t.Errorf("ManuallyStopped = false, want true")
+	}
+}
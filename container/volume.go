@@ -86,6 +86,10 @@ func unMountVolume(id, volume string) error {
 	if err != nil {
 		return err
 	}
+	//容器退出后已经卸载
+	if !isMountPoint(target) {
+		return nil
+	}
 	if _, err := exec.Command("umount", target).CombinedOutput(); err != nil {
 		log.Errorf("umount volume %s failed. %v", target, err)
 		return err
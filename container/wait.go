@@ -0,0 +1,173 @@
+package container
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"syscall"
+	"time"
+
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+)
+
+//容器进程退出后等待 monitor 记录退出状态的时间
+const exitRecordTimeout = 2 * time.Second
+
+//已删除容器的退出码保留时间
+const removedExitTTL = 5 * time.Minute
+
+//使用 --rm 启动的容器删除后，退出状态保存在该目录中，文件名为容器ID
+var DefaultExitLocation string = "/var/run/rocker/exit"
+
+//等待容器退出，返回记录的退出码
+//容器退出后由 monitor 或前台进程写入退出状态，轮询容器配置直到容器启动过且不再运行
+func WaitContainer(containerName string) (int, error) {
+	//容器在开始等待前已经退出并被删除
+	if len(matchContainerIds(containerName)) == 0 {
+		if exitCode, ok := readRemovedExit(containerName); ok {
+			return exitCode, nil
+		}
+	}
+	info, err := GetContainerInfo(containerName)
+	if err != nil {
+		return -1, err
+	}
+
+	log.Debugf("WaitContainer %s 开始等待", info.Name)
+	var exitedAt time.Time
+	//处于 Created 状态的容器从未启动，等待容器启动并退出
+	for info.State.Running || info.State.StartedAt.IsZero() {
+		time.Sleep(100 * time.Millisecond)
+		//使用 --rm 启动的容器退出后配置被删除
+		if !containerInfoExists(info.ID) {
+			if exitCode, ok := readRemovedExit(info.ID); ok {
+				return exitCode, nil
+			}
+			log.Errorf("WaitContainer %s 容器已被删除", containerName)
+			return -1, fmt.Errorf("WaitContainer %s 容器已被删除", containerName)
+		}
+		info, err = readContainerInfo(info.ID)
+		if err != nil {
+			return -1, err
+		}
+		if !info.State.Running || processExists(info.State.Pid) {
+			exitedAt = time.Time{}
+			continue
+		}
+		//monitor 异常退出时不会再记录退出状态
+		if exitedAt.IsZero() {
+			exitedAt = time.Now()
+		} else if time.Since(exitedAt) > exitRecordTimeout {
+			log.Errorf("WaitContainer %s 容器进程 %d 已退出，退出状态未知", containerName, info.State.Pid)
+			return -1, fmt.Errorf("WaitContainer %s 容器进程 %d 已退出，退出状态未知", containerName, info.State.Pid)
+		}
+	}
+	if info.Config.AutoRemove {
+		waitRemoved(info.ID)
+	}
+	return info.State.ExitCode, nil
+}
+
+//等待 monitor 删除使用 --rm 启动的容器
+func waitRemoved(containerId string) {
+	for deadline := time.Now().Add(exitRecordTimeout); time.Now().Before(deadline); {
+		if !containerInfoExists(containerId) {
+			return
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+//进程是否存在，Pid 为 0 时（等待重启）视为存在
+func processExists(pid int) bool {
+	if pid <= 0 {
+		return true
+	}
+	return syscall.Kill(pid, 0) != syscall.ESRCH
+}
+
+//已删除容器的退出状态
+type removedExit struct {
+	ID       string `json:"Id"`
+	Name     string `json:"Name"`
+	ExitCode int    `json:"ExitCode"`
+}
+
+//删除容器前保存退出码，容器配置删除后 wait 仍然可以通过容器ID、ID前缀或名称读取
+//退出码在容器删除 removedExitTTL 后过期，过期的文件在下一次保存时清理
+func RecordRemovedExit(containerId, containerName string, exitCode int) error {
+	if err := os.MkdirAll(DefaultExitLocation, 0755); err != nil {
+		log.Errorf("Mkdir error %s error %v", DefaultExitLocation, err)
+		return err
+	}
+	pruneRemovedExits()
+
+	jsonBytes, err := json.Marshal(removedExit{ID: containerId, Name: containerName, ExitCode: exitCode})
+	if err != nil {
+		log.Errorf("Json marshal error %v", err)
+		return err
+	}
+	fileName := path.Join(DefaultExitLocation, containerId)
+	if err := ioutil.WriteFile(fileName, jsonBytes, 0644); err != nil {
+		log.Errorf("Write file %s error %v", fileName, err)
+		return err
+	}
+	return nil
+}
+
+//读取已删除容器的退出码，读取后不删除，多个 wait 都可以读取
+//完整ID优先，其次为ID前缀或名称，匹配到多个时使用最近删除的容器
+func readRemovedExit(containerName string) (int, bool) {
+	if containerName == "" || strings.Contains(containerName, "/") {
+		return 0, false
+	}
+	files, err := ioutil.ReadDir(DefaultExitLocation)
+	if err != nil {
+		return 0, false
+	}
+
+	var found *removedExit
+	var removedAt time.Time
+	for _, file := range files {
+		if file.IsDir() || time.Since(file.ModTime()) > removedExitTTL {
+			continue
+		}
+		content, err := ioutil.ReadFile(path.Join(DefaultExitLocation, file.Name()))
+		if err != nil {
+			continue
+		}
+		var exit removedExit
+		if err := json.Unmarshal(content, &exit); err != nil {
+			continue
+		}
+		if exit.ID == containerName {
+			return exit.ExitCode, true
+		}
+		if !strings.HasPrefix(exit.ID, containerName) && exit.Name != containerName {
+			continue
+		}
+		if found == nil || file.ModTime().After(removedAt) {
+			found, removedAt = &exit, file.ModTime()
+		}
+	}
+	if found == nil {
+		return 0, false
+	}
+	return found.ExitCode, true
+}
+
+//清理过期的退出码
+func pruneRemovedExits() {
+	files, err := ioutil.ReadDir(DefaultExitLocation)
+	if err != nil {
+		return
+	}
+	for _, file := range files {
+		if !file.IsDir() && time.Since(file.ModTime()) > removedExitTTL {
+			os.Remove(path.Join(DefaultExitLocation, file.Name()))
+		}
+	}
+}
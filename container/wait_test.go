@@ -0,0 +1,194 @@
+package container
+
+import (
+	"os"
+	"os/exec"
+	"path"
+	"testing"
+	"time"
+)
+
+//使用临时目录保存容器配置与退出码
+func setupInfoLocation(t *testing.T) {
+	infoLocation, exitLocation := DefaultInfoLocation, DefaultExitLocation
+	DefaultInfoLocation, DefaultExitLocation = t.TempDir(), t.TempDir()
+	t.Cleanup(func() { DefaultInfoLocation, DefaultExitLocation = infoLocation, exitLocation })
+}
+
+//已经退出并被回收的进程号
+func exitedPid(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Skipf("无法运行 true %v", err)
+	}
+	return cmd.Process.Pid
+}
+
+func TestWaitContainer(t *testing.T) {
+	tests := []struct {
+		name     string
+		state    State
+		wantCode int
+		wantErr  bool
+	}{
+		{"exited", State{ExitCode: 3, StartedAt: time.Now()}, 3, false},
+		{"monitor died", State{Running: true, Pid: -1, StartedAt: time.Now()}, -1, true},
+		{"created", State{}, 5, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupInfoLocation(t)
+			if tt.state.Pid == -1 {
+				tt.state.Pid = exitedPid(t)
+			}
+			info := &ContainerInfo{ID: "0123456789ab", Name: "wait", State: tt.state}
+			if err := save(info); err != nil {
+				t.Fatal(err)
+			}
+			//Created 状态的容器随后启动并退出
+			if tt.state.StartedAt.IsZero() {
+				go func() {
+					time.Sleep(300 * time.Millisecond)
+					RecordContainerStart(info.ID, os.Getpid())
+					time.Sleep(300 * time.Millisecond)
+					RecordContainerExit(info.ID, 5, false)
+				}()
+			}
+			got, err := WaitContainer(info.ID)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WaitContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.wantCode {
+				t.Errorf("WaitContainer() got = %v, want %v", got, tt.wantCode)
+			}
+		})
+	}
+}
+
+//模拟 monitor 删除使用 --rm 启动的容器
+func TestWaitContainerAutoRemove(t *testing.T) {
+	tests := []struct {
+		name       string
+		exited     bool
+		recordExit bool
+		beforeWait bool
+		wantCode   int
+		wantErr    bool
+	}{
+		{"removed while waiting", false, true, false, 7, false},
+		{"removed before wait", false, true, true, 7, false},
+		{"exit code missing", false, false, false, -1, true},
+		{"exited before removal", true, true, false, 7, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setupInfoLocation(t)
+			id := "0123456789ab"
+			info := &ContainerInfo{ID: id, Name: "wait", State: State{Running: true, Pid: os.Getpid()}}
+			if tt.exited {
+				info.State = State{ExitCode: 7}
+			}
+			info.State.StartedAt = time.Now()
+			info.Config.AutoRemove = true
+			if err := save(info); err != nil {
+				t.Fatal(err)
+			}
+			remove := func() {
+				if tt.recordExit {
+					if err := RecordRemovedExit(id, "wait", 7); err != nil {
+						t.Error(err)
+					}
+				}
+				DeleteContainerInfo(id)
+			}
+			if tt.beforeWait {
+				remove()
+			} else {
+				go func() {
+					time.Sleep(300 * time.Millisecond)
+					remove()
+				}()
+			}
+			got, err := WaitContainer(id)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("WaitContainer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.wantCode {
+				t.Errorf("WaitContainer() got = %v, want %v", got, tt.wantCode)
+			}
+			//删除后仍然可以通过ID前缀或名称再次等待
+			if !tt.recordExit {
+				return
+			}
+			for _, name := range []string{id, id[:6], "wait"} {
+				if got, err := WaitContainer(name); err != nil || got != tt.wantCode {
+					t.Errorf("WaitContainer(%s) again got = %v, %v, want %v", name, got, err, tt.wantCode)
+				}
+			}
+		})
+	}
+}
+
+func TestReadRemovedExit(t *testing.T) {
+	setupInfoLocation(t)
+	exits := []struct {
+		id       string
+		name     string
+		exitCode int
+		age      time.Duration
+	}{
+		{"aaaa000000000001", "web", 1, 2 * time.Minute},
+		{"aaaa000000000002", "web", 2, time.Minute},
+		{"bbbb000000000003", "db", 3, 0},
+		{"cccc000000000004", "old", 4, removedExitTTL + time.Minute},
+	}
+	for _, e := range exits {
+		if err := RecordRemovedExit(e.id, e.name, e.exitCode); err != nil {
+			t.Fatal(err)
+		}
+		removedAt := time.Now().Add(-e.age)
+		if err := os.Chtimes(path.Join(DefaultExitLocation, e.id), removedAt, removedAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		wantCode int
+		wantOk   bool
+	}{
+		{"aaaa000000000001", 1, true},
+		{"bbbb", 3, true},
+		{"db", 3, true},
+		{"web", 2, true},
+		{"aaaa", 2, true},
+		{"old", 0, false},
+		{"missing", 0, false},
+		{"", 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := readRemovedExit(tt.name)
+			if ok != tt.wantOk || got != tt.wantCode {
+				t.Errorf("readRemovedExit() got = %v, %v, want %v, %v", got, ok, tt.wantCode, tt.wantOk)
+			}
+		})
+	}
+
+	//保存新的退出码时清理过期的文件
+	if err := RecordRemovedExit("dddd000000000005", "new", 5); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(DefaultExitLocation, "cccc000000000004")); !os.IsNotExist(err) {
+		t.Errorf("过期的退出码未被清理 %v", err)
+	}
+}
+
+func TestProcessExists(t *testing.T) {
+	if !processExists(os.Getpid()) {
+		t.Errorf("processExists(self) = false")
+	}
+	if processExists(exitedPid(t)) {
+		t.Errorf("processExists(exited) = true")
+	}
+}
@@ -32,6 +32,16 @@ func init() {
 //目前暂定rootFs是当前目录下的tar包
 //return 挂载的 mntUrl
 func NewWorkSpace(image, id string) (string, error) {
+	if err := CreateWorkSpace(image, id); err != nil {
+		return "", err
+	}
+
+	mntUrl, err := get(id)
+	return mntUrl, err
+}
+
+//解压镜像并创建容器层，不挂载
+func CreateWorkSpace(image, id string) error {
 	//overlayFS mount
 	//支持将打包的容器 恢复
 	i := image2.Get(image)
@@ -47,29 +57,23 @@ func NewWorkSpace(image, id string) (string, error) {
 	}
 
 	if i == nil {
-		return "", fmt.Errorf("镜像获取失败")
+		return fmt.Errorf("镜像获取失败")
 	}
 
 	//rootfs := strings.Split(rootfsPath, ".")[0]
 	layers, err := getLayersTarFile(i)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	//从底层往上，创建每一层
 	err = LoopExtract(layers, i)
 	if err != nil {
-		return "", err
+		return err
 	}
 
 	//创建容器层
-	err = create(id, layers[len(layers)-1], nil)
-	if err != nil {
-		return "", err
-	}
-
-	mntUrl, err := get(id)
-	return mntUrl, err
+	return create(id, layers[len(layers)-1], nil)
 }
 
 //循环解压image tar包
@@ -97,19 +101,54 @@ func LoopExtract(layers []string, i *image2.Image) error {
 func DelWorkSpace(id string) error {
 	dir := path.Join(home, id)
 
-	mergedDirPath := path.Join(dir, mergedDirName)
-
 	//if _, err := exec.Command("umount", path.Join(mergedDirPath, "/proc")).CombinedOutput(); err != nil {
 	//	log.Errorf("umount  /proc failed. %v", err)
 	//}
 
+	if err := unmountWorkSpace(id); err != nil {
+		return err
+	}
+	log.Infof("RemoveAll %s start.", dir)
+	return os.RemoveAll(dir)
+}
+
+//卸载容器的 volume 与 overlayFS，保留容器层，再次启动时重新挂载
+func UnmountContainer(id string, volumeSlice []string) error {
+	if err := UnMountVolumeSlice(id, volumeSlice); err != nil {
+		return err
+	}
+	return unmountWorkSpace(id)
+}
+
+func unmountWorkSpace(id string) error {
+	mergedDirPath := getMergedPath(id)
+	//容器退出后已经卸载
+	if !isMountPoint(mergedDirPath) {
+		return nil
+	}
+
 	if _, err := exec.Command("umount", mergedDirPath).CombinedOutput(); err != nil {
 		log.Errorf("umount overlayFS %s failed. %v", mergedDirPath, err)
 		return err
 	}
 	log.Infof("umount overlayFS %s done.", mergedDirPath)
-	log.Infof("RemoveAll %s start.", dir)
-	return os.RemoveAll(dir)
+	return nil
+}
+
+//判断目录是否为挂载点
+func isMountPoint(dir string) bool {
+	content, err := ioutil.ReadFile("/proc/self/mountinfo")
+	if err != nil {
+		return false
+	}
+	//36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw,errors=continue
+	for _, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) > 4 && fields[4] == dir {
+			return true
+		}
+	}
+	return false
 }
 
 func get(id string) (string, error) {
@@ -121,6 +160,10 @@ func get(id string) (string, error) {
 	diffDir := path.Join(dir, diffDirName)
 	workDir := path.Join(dir, workDirName)
 	mergeDir := path.Join(dir, mergedDirName)
+	//已经挂载时直接返回，避免重复挂载
+	if isMountPoint(mergeDir) {
+		return mergeDir, nil
+	}
 	lowers, err := ioutil.ReadFile(path.Join(dir, lowerFile))
 	if err != nil {
 		if os.IsNotExist(err) {
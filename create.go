@@ -0,0 +1,113 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RedDragonet/rocker/cgroup"
+	"github.com/RedDragonet/rocker/container"
+	"github.com/RedDragonet/rocker/image"
+	"github.com/RedDragonet/rocker/network"
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+)
+
+//创建容器：记录容器配置，准备容器层与 cgroup，容器处于 Created 状态，由 start 启动
+//网络在启动时连接，创建时只检查网络是否存在
+func createContainer(containerID, containerName string, config container.Config) (info *container.ContainerInfo, retErr error) {
+	if containerName == "" {
+		containerName = containerID[:12]
+	}
+
+	if !config.NetworkMode.IsUserDefined() {
+		if len(config.PortMapping) > 0 {
+			return nil, fmt.Errorf("网络模式 %s 不支持端口映射", config.NetworkMode)
+		}
+		//记录加入容器的ID，容器名称可能重复
+		if config.NetworkMode.IsContainer() {
+			target, err := container.GetContainerInfo(config.NetworkMode.ConnectedContainer())
+			if err != nil {
+				return nil, fmt.Errorf("网络模式 %s 容器不存在", config.NetworkMode)
+			}
+			config.NetworkMode = container.NetworkMode(container.NetworkModeContainer + ":" + target.ID)
+		}
+	}
+
+	//--ip 只能用于 --net 指定的网络
+	if config.StaticIP != nil && len(config.Networks) == 0 {
+		return nil, fmt.Errorf("--ip 需要使用 --net 指定网络")
+	}
+
+	//端口映射使用默认网桥
+	if len(config.PortMapping) > 0 && len(config.Networks) == 0 {
+		config.Networks = []string{DEFAULT_BRIDGE}
+	}
+
+	if len(config.Networks) > 0 {
+		if err := checkNetworks(config.Networks); err != nil {
+			return nil, err
+		}
+	}
+
+	//端口映射发布在第一个网络上
+	if len(config.PortMapping) > 0 {
+		if err := network.CheckPortMapping(config.Networks[0]); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.StaticIP != nil {
+		if err := network.CheckIP(config.Networks[0], config.StaticIP); err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := container.RecordContainerInfo(containerID, containerName, config)
+	if err != nil {
+		log.Errorf("记录容器信息失败 %v", err)
+		return nil, err
+	}
+
+	defer func() {
+		if retErr != nil {
+			container.CleanUp(containerID, config.Volumes)
+		}
+	}()
+
+	image.Init()
+	if err := container.CreateWorkSpace(info.Config.Image, containerID); err != nil {
+		log.Errorf("创建容器层 %s 失败 %v", info.Config.Image, err)
+		return nil, err
+	}
+
+	if err := cgroup.NewCgroupManager(containerID).Set(config.CGroup.ResourceConfig()); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+//检查网络是否存在，同一个网络不能重复连接
+func checkNetworks(networks []string) error {
+	if hasNetwork(networks, DEFAULT_BRIDGE) {
+		createDefaultBridge()
+	}
+	if err := network.Init(); err != nil {
+		return err
+	}
+	for i, net := range networks {
+		if !network.HasNetwork(net) {
+			return fmt.Errorf("未找到对应的网络配置: %s", net)
+		}
+		if hasNetwork(networks[:i], net) {
+			return fmt.Errorf("重复的网络 %s", net)
+		}
+	}
+	return nil
+}
+
+func hasNetwork(networks []string, networkName string) bool {
+	for _, net := range networks {
+		if net == networkName {
+			return true
+		}
+	}
+	return false
+}
@@ -8,7 +8,6 @@ import (
 	"strings"
 
 	"github.com/RedDragonet/rocker/cgroup"
-	"github.com/RedDragonet/rocker/cgroup/subsystem"
 	"github.com/RedDragonet/rocker/container"
 	log "github.com/RedDragonet/rocker/pkg/pidlog"
 )
@@ -30,6 +29,16 @@ func ExecContainer(containerName string, cmdArray []string) {
 		return
 	}
 
+	if !containerInfo.State.Running || containerInfo.State.Restarting {
+		log.Errorf("ExecContainer: 容器 %s 未运行", containerName)
+		return
+	}
+	//被冻结的容器无法运行新的进程
+	if containerInfo.State.Paused {
+		log.Errorf("ExecContainer: 容器 %s 已暂停，请先恢复容器", containerName)
+		return
+	}
+
 	env := getEnvsByPid(containerInfo.State.Pid)
 	env = append(env, ENV_EXEC_PID+"="+strconv.Itoa(containerInfo.State.Pid))
 	env = append(env, ENV_PIPE_COMMAND+"=3")
@@ -67,11 +76,7 @@ func ExecContainer(containerName string, cmdArray []string) {
 	////cgroup初始化
 	cgroupManager := cgroup.NewCgroupManager(containerInfo.ID)
 
-	res := &subsystem.ResourceConfig{
-		MemoryLimit: containerInfo.Config.CGroup.MemoryLimit,
-		CpuShare:    containerInfo.Config.CGroup.CpuShare,
-		CpuSet:      containerInfo.Config.CGroup.CpuSet,
-	}
+	res := containerInfo.Config.CGroup.ResourceConfig()
 	err = cgroupManager.Apply(parent.Process.Pid, res)
 	if err != nil {
 		log.Errorf("ExecContainer: cgroupManager.Apply error %v", err)
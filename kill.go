@@ -0,0 +1,20 @@
+package main
+
+import (
+	"github.com/RedDragonet/rocker/container"
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"github.com/RedDragonet/rocker/pkg/signal"
+)
+
+func KillContainer(containerName string, killSignal string, all bool) error {
+	sig, err := signal.ParseSignal(killSignal)
+	if err != nil {
+		return err
+	}
+
+	if err := container.KillContainer(containerName, sig, all); err != nil {
+		log.Errorf("KillContainer %s error %v", containerName, err)
+		return err
+	}
+	return nil
+}
@@ -11,22 +11,11 @@ import (
 )
 
 func ListContainers() {
-	files, err := ioutil.ReadDir(container.DefaultInfoLocation)
+	containers, err := getAllContainers()
 	if err != nil {
-		log.Errorf("Read dir %s error %v", container.DefaultInfoLocation, err)
 		return
 	}
 
-	var containers []*container.ContainerInfo
-	for _, file := range files {
-		tmpContainer, err := container.GetContainerInfo(file.Name())
-		if err != nil {
-			log.Errorf("Get container info error %v", err)
-			continue
-		}
-		containers = append(containers, tmpContainer)
-	}
-
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
 	fmt.Fprint(w, "ID\tNAME\tPID\tSTATUS\tCOMMAND\tCREATED\n")
 	for _, item := range containers {
@@ -43,3 +32,22 @@ func ListContainers() {
 		return
 	}
 }
+
+func getAllContainers() ([]*container.ContainerInfo, error) {
+	files, err := ioutil.ReadDir(container.DefaultInfoLocation)
+	if err != nil {
+		log.Errorf("Read dir %s error %v", container.DefaultInfoLocation, err)
+		return nil, err
+	}
+
+	var containers []*container.ContainerInfo
+	for _, file := range files {
+		tmpContainer, err := container.GetContainerInfo(file.Name())
+		if err != nil {
+			log.Errorf("Get container info error %v", err)
+			continue
+		}
+		containers = append(containers, tmpContainer)
+	}
+	return containers, nil
+}
@@ -16,11 +16,20 @@ func main() {
 	app.Commands = []*cli.Command{
 		initCommand(),
 		runCommand(),
+		createCommand(),
 		commitCommand(),
 		listCommand(),
 		logCommand(),
 		execCommand(),
+		startCommand(),
 		stopCommand(),
+		restartCommand(),
+		killCommand(),
+		waitCommand(),
+		pauseCommand(),
+		unpauseCommand(),
+		updateCommand(),
+		statsCommand(),
 		removeCommand(),
 		networkCommand(),
 		pullCommand(),
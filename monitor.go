@@ -0,0 +1,224 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/RedDragonet/rocker/cgroup"
+	"github.com/RedDragonet/rocker/cgroup/subsystem"
+	"github.com/RedDragonet/rocker/container"
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"github.com/RedDragonet/rocker/pkg/stringid"
+)
+
+//后台运行的容器由 monitor 进程启动，monitor 等待容器退出并记录退出状态
+//前台进程重新执行 rocker run，并通过环境变量传递容器ID与通知管道
+const (
+	ENV_MONITOR_CONTAINER_ID = "ROCKER_MONITOR_CONTAINER_ID"
+	ENV_MONITOR_READY_PIPE   = "ROCKER_MONITOR_READY_PIPE"
+	MonitorLogFile           = "monitor.log"
+)
+
+//重启等待时间从 100ms 开始翻倍，最长 1 分钟，容器运行超过 10 秒后重新计算
+const (
+	restartBackoffInitial = 100 * time.Millisecond
+	restartBackoffMax     = time.Minute
+	restartResetAfter     = 10 * time.Second
+)
+
+//当前进程是否为 monitor 进程
+func isMonitor() bool {
+	return os.Getenv(ENV_MONITOR_CONTAINER_ID) != ""
+}
+
+//启动 monitor 进程执行 args，等待容器启动完成后返回容器ID
+//containerID 为空时为新建容器生成ID
+func startMonitor(containerID string, args []string) (string, error) {
+	newContainer := containerID == ""
+	if newContainer {
+		containerID = stringid.GenerateRandomID()
+	}
+
+	dirURL := path.Join(container.DefaultInfoLocation, containerID)
+	if err := os.MkdirAll(dirURL, 0755); err != nil {
+		log.Errorf("创建容器目录 %s 失败 %v", dirURL, err)
+		return "", fmt.Errorf("创建容器目录 %s 失败 %v", dirURL, err)
+	}
+	logFile, err := os.Create(path.Join(dirURL, MonitorLogFile))
+	if err != nil {
+		log.Errorf("创建 monitor 日志失败 %v", err)
+		return "", fmt.Errorf("创建 monitor 日志失败 %v", err)
+	}
+	defer logFile.Close()
+
+	readyRead, readyWrite, err := os.Pipe()
+	if err != nil {
+		log.Errorf("新建管道错误 %v", err)
+		return "", fmt.Errorf("新建管道错误 %v", err)
+	}
+	defer readyRead.Close()
+
+	cmd := exec.Command("/proc/self/exe", args...)
+	cmd.Env = append(os.Environ(),
+		ENV_MONITOR_CONTAINER_ID+"="+containerID,
+		ENV_MONITOR_READY_PIPE+"=3",
+	)
+	cmd.ExtraFiles = []*os.File{readyWrite}
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	//脱离当前会话，终端关闭时 monitor 不会退出
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setsid: true}
+
+	if err := cmd.Start(); err != nil {
+		readyWrite.Close()
+		log.Errorf("启动 monitor 进程失败 %v", err)
+		return "", fmt.Errorf("启动 monitor 进程失败 %v", err)
+	}
+	readyWrite.Close()
+
+	//monitor 启动容器后写入容器ID，启动失败时管道直接关闭
+	msg, err := ioutil.ReadAll(readyRead)
+	if err != nil || string(msg) != containerID {
+		//输出 monitor 日志便于排查，并清理新建容器的目录
+		cmd.Wait()
+		//启动失败时容器目录可能已被清理，通过打开的文件读取日志
+		logFile.Seek(0, io.SeekStart)
+		io.Copy(os.Stderr, logFile)
+		if newContainer {
+			os.RemoveAll(dirURL)
+		}
+		log.Errorf("容器启动失败")
+		return "", fmt.Errorf("容器启动失败")
+	}
+	return containerID, nil
+}
+
+//monitor 进程使用前台进程生成的容器ID
+func monitorContainerID() string {
+	containerID := os.Getenv(ENV_MONITOR_CONTAINER_ID)
+	//不传递给容器进程
+	os.Unsetenv(ENV_MONITOR_CONTAINER_ID)
+	return containerID
+}
+
+//通知前台进程容器已启动
+func notifyMonitorReady(containerID string) {
+	fd, err := strconv.Atoi(os.Getenv(ENV_MONITOR_READY_PIPE))
+	os.Unsetenv(ENV_MONITOR_READY_PIPE)
+	if err != nil {
+		return
+	}
+	pipe := os.NewFile(uintptr(fd), "ready")
+	if _, err := pipe.WriteString(containerID); err != nil {
+		log.Errorf("通知容器启动失败 %v", err)
+	}
+	pipe.Close()
+}
+
+//等待容器退出，记录退出码、结束时间以及是否被 OOM Killer 杀死
+//退出后释放 cgroup 并卸载容器目录
+func waitContainer(parent *exec.Cmd, info *container.ContainerInfo) int {
+	containerID := info.ID
+	cgroupManager := cgroup.NewCgroupManager(containerID)
+	oomKillCount, err := subsystem.GetOOMKillCount(cgroupManager.Path)
+	if err != nil {
+		log.Debugf("读取 OOM 次数失败 %v", err)
+	}
+
+	if err := parent.Wait(); err != nil {
+		log.Infof("容器进程退出 %v", err)
+	}
+	exitCode := getExitCode(parent.ProcessState)
+
+	//退出期间 OOM 次数增加，说明容器内有进程被 OOM Killer 杀死
+	oomKilled := false
+	if count, err := subsystem.GetOOMKillCount(cgroupManager.Path); err == nil && count > oomKillCount {
+		oomKilled = true
+	}
+	log.Infof("容器 %s 退出，退出码 %d，OOMKilled=%v", containerID, exitCode, oomKilled)
+
+	cgroupManager.Destroy()
+	if err := container.UnmountContainer(containerID, info.Config.Volumes); err != nil {
+		log.Errorf("卸载容器 %s 失败 %v", containerID, err)
+	}
+	//清理完成后再记录退出，stop/restart 以 Running=false 为准，提前记录会让旧的监控进程清理掉新启动容器的 cgroup 和 rootfs
+	if err := container.RecordContainerExit(containerID, exitCode, oomKilled); err != nil {
+		log.Errorf("记录容器退出状态失败 %v", err)
+	}
+	return exitCode
+}
+
+//等待容器退出，按重启策略重新启动容器，手动 stop 后不再重启
+func superviseContainer(parent *exec.Cmd, info *container.ContainerInfo) {
+	backoff := restartBackoffInitial
+	for {
+		startedAt := time.Now()
+		exitCode := waitContainer(parent, info)
+
+		current, err := container.GetContainerInfo(info.ID)
+		if err != nil {
+			return
+		}
+		policy := current.Config.RestartPolicy
+		if !policy.ShouldRestart(exitCode, current.RestartCount, current.State.ManuallyStopped) {
+			return
+		}
+
+		if time.Since(startedAt) > restartResetAfter {
+			backoff = restartBackoffInitial
+		}
+		if _, err := container.RecordContainerRestarting(info.ID); err != nil {
+			log.Errorf("记录容器重启状态失败 %v", err)
+			return
+		}
+		log.Infof("容器 %s 退出码 %d，%v 后按重启策略 %s 重启", info.Name, exitCode, backoff, policy.Name)
+		time.Sleep(backoff)
+		backoff *= 2
+		if backoff > restartBackoffMax {
+			backoff = restartBackoffMax
+		}
+
+		//等待期间被手动停止
+		current, err = container.GetContainerInfo(info.ID)
+		if err != nil || !current.State.Restarting {
+			log.Infof("容器 %s 已停止，取消重启", info.Name)
+			return
+		}
+		parent, err = startContainer(current, false, false)
+		if err != nil {
+			log.Errorf("容器 %s 重启失败 %v", info.Name, err)
+			container.RecordContainerExit(info.ID, exitCode, false)
+			return
+		}
+		info = current
+	}
+}
+
+//使用 --rm 启动的容器退出后删除容器目录、容器层、cgroup、端口映射与IP地址
+func autoRemoveContainer(containerID string) {
+	info, err := container.GetContainerInfo(containerID)
+	if err != nil || !info.Config.AutoRemove || info.State.Running {
+		return
+	}
+	log.Infof("容器 %s 已退出，自动删除", info.Name)
+	if err := container.RecordRemovedExit(info.ID, info.Name, info.State.ExitCode); err != nil {
+		log.Errorf("保存容器 %s 退出码失败 %v", info.Name, err)
+	}
+	container.CleanUp(info.ID, info.Config.Volumes)
+	releaseNetwork(info)
+}
+
+//被信号杀死时退出码为 128+信号值，与 shell 一致
+func getExitCode(state *os.ProcessState) int {
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+	return state.ExitCode()
+}
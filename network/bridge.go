@@ -3,13 +3,18 @@ package network
 import (
 	"fmt"
 	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"github.com/RedDragonet/rocker/pkg/stringid"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 	"net"
 	"os/exec"
 	"strings"
 	"time"
 )
 
+//Bridge 名称，默认与网络名称相同
+const OptionBridgeName = "com.rocker.bridge.name"
+
 type BridgeNetworkDriver struct {
 }
 
@@ -18,21 +23,23 @@ func (d *BridgeNetworkDriver) Name() string {
 }
 
 func (d *BridgeNetworkDriver) initBridge(n *Network) error {
-	bridgeName := n.Name
+	bridgeName := getBridgeName(n)
 	if err := createBridgeInterface(bridgeName); err != nil {
 		log.Errorf("创建bridge %s 失败 %v", bridgeName, err)
 		return fmt.Errorf("创建bridge %s 失败 %v", bridgeName, err)
 	}
 
-	gatewayIP := *n.IpRange
-	gatewayIP.IP = n.IpRange.IP
+	for _, ipRange := range n.ipRanges() {
+		gatewayIP := *ipRange
+		gatewayIP.IP = ipRange.IP
 
-	//设置 Bridge IP
-	if err := setInterfaceIP(bridgeName, gatewayIP.String()); err != nil {
-		log.Errorf("分配 IP 地址: %s 到 bridge: %s 失败: %v ", gatewayIP, bridgeName, err)
-		return fmt.Errorf("分配 IP 地址: %s 到 bridge: %s 失败: %v ", gatewayIP, bridgeName, err)
+		//设置 Bridge IP
+		if err := setInterfaceIP(bridgeName, gatewayIP.String()); err != nil {
+			log.Errorf("分配 IP 地址: %s 到 bridge: %s 失败: %v ", gatewayIP, bridgeName, err)
+			return fmt.Errorf("分配 IP 地址: %s 到 bridge: %s 失败: %v ", gatewayIP, bridgeName, err)
+		}
+		log.Debugf("分配 IP 地址: %s 到 bridge: %s ", gatewayIP, bridgeName)
 	}
-	log.Debugf("分配 IP 地址: %s 到 bridge: %s ", gatewayIP, bridgeName)
 
 	//启动 Bridge
 	if err := setInterfaceUP(bridgeName); err != nil {
@@ -42,32 +49,40 @@ func (d *BridgeNetworkDriver) initBridge(n *Network) error {
 	log.Debugf("Bridge  %s, 启动", bridgeName)
 
 	//设置 IPTable
-	if err := setupIPTables(bridgeName, n.IpRange); err != nil {
-		log.Errorf("Bridge %s IPtable 设置失败: %v ", bridgeName, err)
-		return fmt.Errorf("Bridge %s IPtable 设置失败: %v ", bridgeName, err)
+	for _, ipRange := range n.ipRanges() {
+		setup := setupIPTables
+		if ipRange.IP.To4() == nil {
+			setup = setupIP6Tables
+		}
+		if err := setup(bridgeName, ipRange); err != nil {
+			log.Errorf("Bridge %s IPtable 设置失败: %v ", bridgeName, err)
+			return fmt.Errorf("Bridge %s IPtable 设置失败: %v ", bridgeName, err)
+		}
 	}
 
 	return nil
 }
 
-func (d *BridgeNetworkDriver) Create(subnet string, name string) (*Network, error) {
-	ip, ipRange, _ := net.ParseCIDR(subnet)
-	ipRange.IP = ip
-	n := &Network{
-		Name:    name,
-		IpRange: ipRange,
-		Driver:  d.Name(),
+func (d *BridgeNetworkDriver) Create(n *Network) error {
+	if name, ok := n.Options[OptionBridgeName]; ok {
+		if name == "" || len(name) >= unix.IFNAMSIZ || strings.ContainsAny(name, "/ ") {
+			return fmt.Errorf("错误的 -o %s=%s，长度不能超过 %d", OptionBridgeName, name, unix.IFNAMSIZ-1)
+		}
 	}
 	err := d.initBridge(n)
 	if err != nil {
 		log.Errorf("创建 bridge 失败: %v", err)
 	}
 
-	return n, err
+	return err
 }
 
 func (d *BridgeNetworkDriver) Delete(network Network) error {
-	bridgeName := network.Name
+	bridgeName := getBridgeName(&network)
+	for _, ipRange := range network.ipRanges() {
+		cleanIPTables(bridgeName, ipRange)
+	}
+
 	//判断是否已经创建过
 	br, err := netlink.LinkByName(bridgeName)
 	if err != nil {
@@ -84,21 +99,24 @@ func (d *BridgeNetworkDriver) Delete(network Network) error {
 
 //链接网络和端点
 func (d *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) error {
-	bridgeName := network.Name
+	bridgeName := getBridgeName(network)
 	br, err := netlink.LinkByName(bridgeName)
 	if err != nil {
 		return err
 	}
 
+	//一个容器可以连接多个网络，veth 名称使用随机ID避免冲突
+	suffix := stringid.GenerateRandomID()[:7]
 	la := netlink.NewLinkAttrs()
-	la.Name = endpoint.ID[:5]
+	la.Name = "veth" + suffix
 	la.MasterIndex = br.Attrs().Index
 
 	// Veth
 	endpoint.Device = netlink.Veth{
 		LinkAttrs: la,
-		PeerName:  "cif-" + la.Name,
+		PeerName:  "cif-" + suffix,
 	}
+	endpoint.InterfaceName = endpoint.Device.PeerName
 
 	//创建端点 Veth
 	if err = netlink.LinkAdd(&endpoint.Device); err != nil {
@@ -112,7 +130,23 @@ func (d *BridgeNetworkDriver) Connect(network *Network, endpoint *Endpoint) erro
 	return nil
 }
 
+//断开网络和端点，将 veth 从 Bridge 上移除并删除
+//容器进程退出后 veth 随 network namespace 一起销毁，此时无需处理
 func (d *BridgeNetworkDriver) Disconnect(network Network, endpoint *Endpoint) error {
+	vethName := endpoint.Device.Name
+	veth, err := netlink.LinkByName(vethName)
+	if err != nil {
+		log.Debugf("端点 veth %s 不存在，跳过 %v", vethName, err)
+		return nil
+	}
+
+	if err = netlink.LinkSetNoMaster(veth); err != nil {
+		return fmt.Errorf("从 Bridge %s 移除端点 %s 失败: %v ", getBridgeName(&network), vethName, err)
+	}
+
+	if err = netlink.LinkDel(veth); err != nil {
+		return fmt.Errorf("删除端点 %s 失败: %v ", vethName, err)
+	}
 	return nil
 }
 
@@ -182,26 +216,79 @@ func setInterfaceIP(name string, rawIP string) error {
 		return err
 	}
 	addr := &netlink.Addr{IPNet: ipNet, Peer: ipNet, Label: "", Flags: 0, Scope: 0, Broadcast: nil}
+	//IPv6 地址跳过重复地址检测，配置后立即可用
+	if ipNet.IP.To4() == nil {
+		addr.Flags = unix.IFA_F_NODAD
+	}
 	log.Debugf("setInterfaceIP: %s ", addr)
 	return netlink.AddrAdd(iface, addr)
 }
 
 //设置 IPTable
 func setupIPTables(bridgeName string, subnet *net.IPNet) error {
-	iptablesCmd := fmt.Sprintf("-t nat -A POSTROUTING -s %s ! -o %s -j MASQUERADE", subnet.String(), bridgeName)
-	cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
-	output, err := cmd.Output()
-	if err != nil {
-		log.Errorf("iptables 设置失败, %v ", output)
+	iptables, rules := bridgeIPTablesRules("-A", bridgeName, subnet)
+	var err error
+	for _, iptablesCmd := range rules {
+		cmd := exec.Command(iptables, strings.Split(iptablesCmd, " ")...)
+		var output []byte
+		output, err = cmd.Output()
+		if err != nil {
+			log.Errorf("iptables 设置失败, %v ", output)
+		}
+		log.Debugf("driver %s , iptables  设置 %s ", bridgeName, iptablesCmd)
 	}
-	log.Debugf("driver %s , iptables  设置 %s ", bridgeName, iptablesCmd)
+	return err
+}
 
-	iptablesCmd = fmt.Sprintf("-t nat -A POSTROUTING -o %s -m addrtype --src-type LOCAL --dst-type UNICAST -j MASQUERADE", bridgeName)
-	cmd = exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
-	output, err = cmd.Output()
-	if err != nil {
-		log.Errorf("iptables LOCALHOST 设置失败, %v ", output)
+//设置 IPv6 的 IPTable，容器访问外部网络时做地址转换，并允许 Bridge 转发
+func setupIP6Tables(bridgeName string, subnet *net.IPNet) error {
+	ip6tables, rules := bridgeIPTablesRules("-A", bridgeName, subnet)
+	for _, ip6tablesCmd := range rules {
+		cmd := exec.Command(ip6tables, strings.Split(ip6tablesCmd, " ")...)
+		output, err := cmd.CombinedOutput()
+		if err != nil {
+			log.Errorf("ip6tables 设置失败, %s %v ", output, err)
+			return fmt.Errorf("ip6tables 设置失败 %s %v", ip6tablesCmd, err)
+		}
+		log.Debugf("driver %s , ip6tables 设置 %s ", bridgeName, ip6tablesCmd)
 	}
-	log.Debugf("driver %s , iptables LOCALHOST 设置 %s ", bridgeName, iptablesCmd)
-	return err
+	return nil
+}
+
+//删除网络时清理 Bridge 的 IPTable 规则
+func cleanIPTables(bridgeName string, subnet *net.IPNet) {
+	iptables, rules := bridgeIPTablesRules("-D", bridgeName, subnet)
+	for _, iptablesCmd := range rules {
+		cmd := exec.Command(iptables, strings.Split(iptablesCmd, " ")...)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			log.Warnf("%s 删除失败 %s %s %v", iptables, iptablesCmd, output, err)
+			continue
+		}
+		log.Debugf("driver %s , %s 删除 %s ", bridgeName, iptables, iptablesCmd)
+	}
+}
+
+//Bridge 的 IPTable 规则，action 为 -A 添加或 -D 删除
+//IPv4 额外为 localhost 端口映射做地址转换，IPv6 需要允许 Bridge 转发
+func bridgeIPTablesRules(action, bridgeName string, subnet *net.IPNet) (string, []string) {
+	if subnet.IP.To4() == nil {
+		return "ip6tables", []string{
+			fmt.Sprintf("-t nat %s POSTROUTING -s %s ! -o %s -j MASQUERADE", action, subnet.String(), bridgeName),
+			fmt.Sprintf("%s FORWARD -i %s -j ACCEPT", action, bridgeName),
+			fmt.Sprintf("%s FORWARD -o %s -j ACCEPT", action, bridgeName),
+		}
+	}
+	return "iptables", []string{
+		fmt.Sprintf("-t nat %s POSTROUTING -s %s ! -o %s -j MASQUERADE", action, subnet.String(), bridgeName),
+		//LOCALHOST
+		fmt.Sprintf("-t nat %s POSTROUTING -o %s -m addrtype --src-type LOCAL --dst-type UNICAST -j MASQUERADE", action, bridgeName),
+	}
+}
+
+//Bridge 的名称，默认与网络名称相同，可以通过 -o com.rocker.bridge.name 指定
+func getBridgeName(n *Network) string {
+	if name := n.Options[OptionBridgeName]; name != "" {
+		return name
+	}
+	return n.Name
 }
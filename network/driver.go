@@ -2,7 +2,7 @@ package network
 
 type NetworkDriver interface {
 	Name() string
-	Create(subnet string, name string) (*Network, error)
+	Create(network *Network) error
 	Delete(network Network) error
 	Connect(network *Network, endpoint *Endpoint) error
 	Disconnect(network Network, endpoint *Endpoint) error
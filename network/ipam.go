@@ -3,164 +3,398 @@ package network
 import (
 	"encoding/json"
 	"fmt"
-	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"io/ioutil"
+	"math"
+	"math/big"
 	"net"
 	"os"
 	"path"
-	"strings"
+
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"golang.org/x/sys/unix"
 )
 
 const ipamDefaultAllocatorPath = "/var/run/rocker/network/ipam/subnet.json"
 
+//位图按块保存，每块记录 2^16 个地址
+const chunkBits = 16
+
+//分配信息的文件格式版本，旧版本为每个地址一个 '0'/'1' 字符
+const ipamStateVersion = 2
+
+//IPAM 使用位图记录每个网段的地址分配情况，第 n 位对应网段中偏移量为 n 的地址
+//只记录网段中的前 2^64 个地址
+//读写分配信息期间持有文件锁，多个 rocker 进程可以同时分配
 type IPAM struct {
 	SubnetAllocatorPath string
-	Subnets             *map[string]string
+	Subnets             map[string]bitmap
+}
+
+//分配信息文件的内容
+type ipamState struct {
+	Version int               `json:"Version"`
+	Subnets map[string]bitmap `json:"Subnets"`
 }
 
 var ipAllocator = &IPAM{
 	SubnetAllocatorPath: ipamDefaultAllocatorPath,
 }
 
-func (ipam *IPAM) load() error {
-	if _, err := os.Stat(ipam.SubnetAllocatorPath); err != nil {
-		if os.IsNotExist(err) {
+//分配网段中第一个可用的地址
+func (ipam *IPAM) Allocate(subnet *net.IPNet) (ip net.IP, err error) {
+	return ipam.AllocateInRange(subnet, nil)
+}
+
+//分配网段中 ipRange 范围内第一个可用的地址，ipRange 为 nil 时在整个网段中分配
+func (ipam *IPAM) AllocateInRange(subnet *net.IPNet, ipRange *net.IPNet) (ip net.IP, err error) {
+	subnet = networkOf(subnet)
+	err = ipam.update(func() error {
+		alloc := ipam.subnetBitmap(subnet)
+		first, last := usableRange(subnet)
+		if ipRange != nil {
+			first, last = clampRange(subnet, ipRange, first, last)
+		}
+		if c, ok := alloc.firstClear(first, last); ok {
+			alloc.set(c)
+			ip = ipAdd(subnet.IP, c)
 			return nil
-		} else {
+		}
+		log.Errorf("网段 %s 没有可分配的IP地址", subnet.String())
+		return fmt.Errorf("网段 %s 没有可分配的IP地址", subnet.String())
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ip, nil
+}
+
+//分配指定的地址，地址已被占用或不可分配时返回错误
+func (ipam *IPAM) AllocateIP(subnet *net.IPNet, ip net.IP) error {
+	subnet = networkOf(subnet)
+	return ipam.update(func() error {
+		c, err := allocatableOffset(subnet, ip)
+		if err != nil {
+			return err
+		}
+		alloc := ipam.subnetBitmap(subnet)
+		if alloc.isSet(c) {
+			return fmt.Errorf("IP地址 %s 已被占用", ip.String())
+		}
+		alloc.set(c)
+		return nil
+	})
+}
+
+//释放地址，网段中的地址全部释放后删除网段的分配信息
+func (ipam *IPAM) Release(subnet *net.IPNet, ip net.IP) error {
+	subnet = networkOf(subnet)
+	return ipam.update(func() error {
+		c, err := allocatableOffset(subnet, ip)
+		if err != nil {
 			return err
 		}
+		alloc, ok := ipam.Subnets[subnet.String()]
+		if !ok {
+			return nil
+		}
+		alloc.clear(c)
+		if alloc.empty() {
+			delete(ipam.Subnets, subnet.String())
+		}
+		return nil
+	})
+}
+
+//网段的位图，不存在时新建
+func (ipam *IPAM) subnetBitmap(subnet *net.IPNet) bitmap {
+	alloc, ok := ipam.Subnets[subnet.String()]
+	if !ok {
+		alloc = bitmap{}
+		ipam.Subnets[subnet.String()] = alloc
 	}
-	subnetConfigFile, err := os.Open(ipam.SubnetAllocatorPath)
-	defer subnetConfigFile.Close()
-	if err != nil {
+	return alloc
+}
+
+//持有文件锁读取分配信息并执行 fn，fn 返回 nil 时保存修改后的分配信息
+func (ipam *IPAM) update(fn func() error) error {
+	ipamConfigFileDir := path.Dir(ipam.SubnetAllocatorPath)
+	if err := os.MkdirAll(ipamConfigFileDir, 0755); err != nil {
 		return err
 	}
-	subnetJson := make([]byte, 2000)
-	n, err := subnetConfigFile.Read(subnetJson)
+
+	lockFile, err := os.OpenFile(ipam.SubnetAllocatorPath+".lock", os.O_RDWR|os.O_CREATE, 0644)
 	if err != nil {
 		return err
 	}
+	defer lockFile.Close()
+	if err := unix.Flock(int(lockFile.Fd()), unix.LOCK_EX); err != nil {
+		log.Errorf("获取 IPAM 文件锁失败, %v", err)
+		return fmt.Errorf("获取 IPAM 文件锁失败, %v", err)
+	}
+	defer unix.Flock(int(lockFile.Fd()), unix.LOCK_UN)
 
-	err = json.Unmarshal(subnetJson[:n], ipam.Subnets)
-	if err != nil {
-		log.Errorf("Error dump allocation info, %v", err)
+	if err := ipam.load(); err != nil {
+		log.Errorf("获取 IPAM 配置信息错误, %v", err)
+		return fmt.Errorf("获取 IPAM 配置信息错误, %v", err)
+	}
+	if err := fn(); err != nil {
 		return err
 	}
+	if err := ipam.dump(); err != nil {
+		log.Errorf("保存分配的IP失败, %v", err)
+		return fmt.Errorf("保存分配的IP失败, %v", err)
+	}
 	return nil
 }
 
-func (ipam *IPAM) dump() error {
-	ipamConfigFileDir, _ := path.Split(ipam.SubnetAllocatorPath)
-	if _, err := os.Stat(ipamConfigFileDir); err != nil {
+func (ipam *IPAM) load() error {
+	ipam.Subnets = map[string]bitmap{}
+
+	subnetJson, err := ioutil.ReadFile(ipam.SubnetAllocatorPath)
+	if err != nil {
 		if os.IsNotExist(err) {
-			err := os.MkdirAll(ipamConfigFileDir, 0644)
-			if err != nil {
-				return err
-			}
-		} else {
-			return err
+			return nil
 		}
+		return err
 	}
-	subnetConfigFile, err := os.OpenFile(ipam.SubnetAllocatorPath, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
-	defer subnetConfigFile.Close()
-	if err != nil {
+
+	state := ipamState{}
+	if err := json.Unmarshal(subnetJson, &state); err != nil {
 		return err
 	}
+	if state.Version == 0 {
+		return ipam.loadLegacy(subnetJson)
+	}
+	if state.Subnets != nil {
+		ipam.Subnets = state.Subnets
+	}
+	return nil
+}
 
-	ipamConfigJson, err := json.Marshal(ipam.Subnets)
+//读取旧版本的分配信息，第 c 个字符为 '1' 表示偏移量为 c+1 的地址已分配
+func (ipam *IPAM) loadLegacy(subnetJson []byte) error {
+	legacy := map[string]string{}
+	if err := json.Unmarshal(subnetJson, &legacy); err != nil {
+		return err
+	}
+	for cidr, ipalloc := range legacy {
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			continue
+		}
+		alloc := ipam.subnetBitmap(subnet)
+		for c := range ipalloc {
+			if ipalloc[c] == '1' && uint64(c)+1 <= lastOffset(subnet) {
+				alloc.set(uint64(c) + 1)
+			}
+		}
+		if alloc.empty() {
+			delete(ipam.Subnets, subnet.String())
+		}
+	}
+	return nil
+}
+
+//先写入临时文件再重命名，保证分配信息不会被写坏
+func (ipam *IPAM) dump() error {
+	ipamConfigJson, err := json.Marshal(ipamState{
+		Version: ipamStateVersion,
+		Subnets: ipam.Subnets,
+	})
 	if err != nil {
 		return err
 	}
 
-	_, err = subnetConfigFile.Write(ipamConfigJson)
+	tmpFileName := ipam.SubnetAllocatorPath + ".tmp"
+	subnetConfigFile, err := os.OpenFile(tmpFileName, os.O_TRUNC|os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		return err
 	}
+	if _, err := subnetConfigFile.Write(ipamConfigJson); err != nil {
+		subnetConfigFile.Close()
+		return err
+	}
+	if err := subnetConfigFile.Sync(); err != nil {
+		subnetConfigFile.Close()
+		return err
+	}
+	if err := subnetConfigFile.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpFileName, ipam.SubnetAllocatorPath)
+}
 
-	return nil
+//地址分配位图，按块记录，只保存有地址分配的块
+//每块的长度按需增长，JSON 中键为块序号，值为 base64 字符串
+type bitmap map[uint64][]byte
+
+func (b bitmap) isSet(i uint64) bool {
+	chunk, j := b[i>>chunkBits], i&(1<<chunkBits-1)
+	return j/8 < uint64(len(chunk)) && chunk[j/8]&(1<<(j%8)) != 0
 }
 
-func (ipam *IPAM) Allocate(subnet *net.IPNet) (ip net.IP, err error) {
-	// 存放网段中地址分配信息的数组
-	ipam.Subnets = &map[string]string{}
+func (b bitmap) set(i uint64) {
+	n, j := i>>chunkBits, i&(1<<chunkBits-1)
+	chunk := b[n]
+	if need := int(j/8) + 1; len(chunk) < need {
+		chunk = append(chunk, make([]byte, need-len(chunk))...)
+		b[n] = chunk
+	}
+	chunk[j/8] |= 1 << (j % 8)
+}
 
-	// 从文件中加载已经分配的网段信息
-	err = ipam.load()
-	if err != nil {
-		log.Errorf("获取 IPAM 配置信息错误, %v", err)
-		err = fmt.Errorf("获取 IPAM 配置信息错误, %v", err)
+func (b bitmap) clear(i uint64) {
+	n, j := i>>chunkBits, i&(1<<chunkBits-1)
+	chunk := b[n]
+	if j/8 >= uint64(len(chunk)) {
 		return
 	}
+	chunk[j/8] &^= 1 << (j % 8)
+	b.trim(n)
+}
 
-	_, subnet, _ = net.ParseCIDR(subnet.String())
+//去掉块末尾的空字节，块为空时删除
+func (b bitmap) trim(n uint64) {
+	chunk := b[n]
+	for len(chunk) > 0 && chunk[len(chunk)-1] == 0 {
+		chunk = chunk[:len(chunk)-1]
+	}
+	if len(chunk) == 0 {
+		delete(b, n)
+		return
+	}
+	b[n] = chunk
+}
 
-	// 掩码前部位数和总位数
-	leading, size := subnet.Mask.Size()
+func (b bitmap) empty() bool {
+	return len(b) == 0
+}
 
-	if _, exist := (*ipam.Subnets)[subnet.String()]; !exist {
-		//可以被分配的IP总数 (2^剩余位数)
-		(*ipam.Subnets)[subnet.String()] = strings.Repeat("0", 1<<uint8(size-leading))
-	}
-	for c := range ((*ipam.Subnets)[subnet.String()]) {
-		//循环找到为被分配的位置
-		if (*ipam.Subnets)[subnet.String()][c] == '0' {
-			ipalloc := []byte((*ipam.Subnets)[subnet.String()])
-			//超出可分配上限（-2 为 0/255 网关/广播 地址不允许分配）
-			if len(ipalloc)-2 < c {
-				log.Errorf("超出可分配IP上限 %d", len(ipalloc))
-				err = fmt.Errorf("超出可分配IP上限 %d", len(ipalloc))
-				return
-			}
-			//空位置1
-			ipalloc[c] = '1'
-			(*ipam.Subnets)[subnet.String()] = string(ipalloc)
-			ip = subnet.IP
-			for t := uint(4); t > 0; t -= 1 {
-				//从 HOST ID => Network ID 循环处理每一段，如    0 | 0 | 168 | 192
-				[]byte(ip)[4-t] += uint8(c >> ((t - 1) * 8))
-			}
-			//可用地址从1开始（）
-			ip[3] += 1
+//[first, last] 范围内第一个未分配的偏移量
+//已分配的地址集中在少数块中，跳过已满的字节与未记录的部分，不需要逐个检查整个范围
+func (b bitmap) firstClear(first, last uint64) (uint64, bool) {
+	for c := first; c <= last; {
+		chunk, j := b[c>>chunkBits], c&(1<<chunkBits-1)
+		if j/8 >= uint64(len(chunk)) || chunk[j/8]&(1<<(j%8)) == 0 {
+			return c, true
+		}
+		step := uint64(1)
+		if j%8 == 0 && chunk[j/8] == 0xff {
+			step = 8
+		}
+		if c+step < c {
+			//溢出
 			break
 		}
+		c += step
 	}
+	return 0, false
+}
 
-	err = ipam.dump()
-	if err != nil {
-		log.Errorf("保存分配的IP失败, %v", err)
-		err = fmt.Errorf("保存分配的IP失败, %v", err)
-		return
+//网段的网络地址，如 192.168.1.1/24 为 192.168.1.0/24
+func networkOf(subnet *net.IPNet) *net.IPNet {
+	ip := subnet.IP
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
 	}
-	return
+	return &net.IPNet{IP: ip.Mask(subnet.Mask), Mask: subnet.Mask}
 }
 
-func (ipam *IPAM) Release(subnet *net.IPNet, ipaddr *net.IP) error {
-	ipam.Subnets = &map[string]string{}
+//网段中最后一个可以记录的偏移量
+//主机位超过 64 位时只记录网段中的前 2^64 个地址
+func lastOffset(subnet *net.IPNet) uint64 {
+	leading, size := subnet.Mask.Size()
+	if size-leading >= 64 {
+		return math.MaxUint64
+	}
+	return 1<<uint(size-leading) - 1
+}
 
-	_, subnet, _ = net.ParseCIDR(subnet.String())
+//网段中可以分配的偏移量范围
+//网络地址（IPv6 为子网路由器任播地址）与 IPv4 广播地址不能分配，/31、/32、/127、/128 所有地址都可以分配
+func usableRange(subnet *net.IPNet) (first, last uint64) {
+	leading, size := subnet.Mask.Size()
+	last = lastOffset(subnet)
+	if size-leading <= 1 {
+		return 0, last
+	}
+	if subnet.IP.To4() != nil {
+		last--
+	}
+	return 1, last
+}
 
-	err := ipam.load()
-	if err != nil {
-		log.Errorf("获取 IPAM 配置信息错误, %v", err)
-		return fmt.Errorf("获取 IPAM 配置信息错误, %v", err)
+//将可分配的偏移量范围限制在 ipRange 内
+func clampRange(subnet, ipRange *net.IPNet, first, last uint64) (uint64, uint64) {
+	ipRange = networkOf(ipRange)
+	start, ok := ipOffset(subnet, ipRange.IP)
+	if !ok {
+		return 1, 0
+	}
+	if start > first {
+		first = start
+	}
+	//IPv6 地址范围可能超出记录的 2^64 个地址，超出时不限制
+	if end, ok := ipOffset(subnet, lastIP(ipRange)); ok && end < last {
+		last = end
 	}
+	return first, last
+}
 
-	c := 0
-	releaseIP := ipaddr.To4()
-	//可用地址从1开始，减去1
-	releaseIP[3] -= 1
-	for t := uint(4); t > 0; t -= 1 {
-		//找到c 在 ipalloc 的位置
-		c += int(releaseIP[t-1]-subnet.IP[t-1]) << ((4 - t) * 8)
+//网段中的最后一个地址
+func lastIP(subnet *net.IPNet) net.IP {
+	subnet = networkOf(subnet)
+	ip := make(net.IP, len(subnet.IP))
+	for i := range subnet.IP {
+		ip[i] = subnet.IP[i] | ^subnet.Mask[i]
 	}
+	return ip
+}
 
-	ipalloc := []byte((*ipam.Subnets)[subnet.String()])
-	ipalloc[c] = '0'
-	(*ipam.Subnets)[subnet.String()] = string(ipalloc)
+//可以分配的地址在网段中的偏移量
+func allocatableOffset(subnet *net.IPNet, ip net.IP) (uint64, error) {
+	if !subnet.Contains(ip) {
+		return 0, fmt.Errorf("IP地址 %s 不在网段 %s 中", ip.String(), subnet.String())
+	}
+	c, ok := ipOffset(subnet, ip)
+	if !ok {
+		return 0, fmt.Errorf("IP地址 %s 超出网段 %s 的前 2^64 个地址，不能分配", ip.String(), subnet.String())
+	}
+	first, last := usableRange(subnet)
+	if c < first || c > last {
+		return 0, fmt.Errorf("IP地址 %s 不能分配", ip.String())
+	}
+	return c, nil
+}
 
-	err = ipam.dump()
-	if err != nil {
-		log.Errorf("释放分配的IP失败, %v", err)
-		return fmt.Errorf("释放分配的IP失败, %v", err)
+//IP地址加上偏移量，IPv4 与 IPv6 通用
+func ipAdd(ip net.IP, offset uint64) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
 	}
-	return nil
+	sum := new(big.Int).Add(new(big.Int).SetBytes(ip), new(big.Int).SetUint64(offset))
+	result := make(net.IP, len(ip))
+	b := sum.Bytes()
+	if len(b) > len(result) {
+		//溢出
+		return nil
+	}
+	copy(result[len(result)-len(b):], b)
+	return result
+}
+
+//IP地址在网段中的偏移量
+func ipOffset(subnet *net.IPNet, ip net.IP) (uint64, bool) {
+	if !subnet.Contains(ip) {
+		return 0, false
+	}
+	base, addr := subnet.IP, ip
+	if ip4 := ip.To4(); ip4 != nil {
+		base, addr = subnet.IP.To4(), ip4
+	}
+	offset := new(big.Int).Sub(new(big.Int).SetBytes(addr), new(big.Int).SetBytes(base))
+	if !offset.IsUint64() {
+		return 0, false
+	}
+	return offset.Uint64(), true
 }
@@ -0,0 +1,215 @@
+package network
+
+import (
+	"io/ioutil"
+	"math"
+	"net"
+	"path"
+	"testing"
+)
+
+func TestIPAMAllocate(t *testing.T) {
+	tests := []struct {
+		name   string
+		subnet string
+		want   []string
+	}{
+		{"ipv4", "192.168.1.0/24", []string{"192.168.1.1", "192.168.1.2", "192.168.1.3"}},
+		{"ipv4 carry", "10.0.0.0/23", []string{"10.0.0.1", "10.0.0.2"}},
+		{"ipv4 broadcast", "10.1.0.0/30", []string{"10.1.0.1", "10.1.0.2", ""}},
+		{"ipv6", "fd00::/64", []string{"fd00::1", "fd00::2"}},
+		{"ipv6 small", "fd01::/126", []string{"fd01::1", "fd01::2", "fd01::3", ""}},
+		{"point to point", "10.2.0.0/31", []string{"10.2.0.0", "10.2.0.1", ""}},
+		{"host", "10.3.0.5/32", []string{"10.3.0.5", ""}},
+		{"gateway as subnet", "172.16.0.1/16", []string{"172.16.0.1", "172.16.0.2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipam := &IPAM{SubnetAllocatorPath: path.Join(t.TempDir(), "subnet.json")}
+			_, subnet, _ := net.ParseCIDR(tt.subnet)
+			for _, want := range tt.want {
+				got, err := ipam.Allocate(subnet)
+				if want == "" {
+					if err == nil {
+						t.Errorf("Allocate() got = %v, want error", got)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("Allocate() error = %v", err)
+				}
+				if !got.Equal(net.ParseIP(want)) {
+					t.Errorf("Allocate() got = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestIPAMRelease(t *testing.T) {
+	tests := []struct {
+		name   string
+		subnet string
+	}{
+		{"ipv4", "192.168.1.0/24"},
+		{"ipv6", "fd00::/64"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipam := &IPAM{SubnetAllocatorPath: path.Join(t.TempDir(), "subnet.json")}
+			_, subnet, _ := net.ParseCIDR(tt.subnet)
+			first, _ := ipam.Allocate(subnet)
+			second, _ := ipam.Allocate(subnet)
+			if err := ipam.Release(subnet, first); err != nil {
+				t.Fatalf("Release() error = %v", err)
+			}
+			got, err := ipam.Allocate(subnet)
+			if err != nil {
+				t.Fatalf("Allocate() error = %v", err)
+			}
+			if !got.Equal(first) || got.Equal(second) {
+				t.Errorf("Allocate() after Release got = %v, want %v", got, first)
+			}
+		})
+	}
+}
+
+func TestIPAMAllocateIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnet  string
+		ip      string
+		wantErr bool
+	}{
+		{"ipv4", "192.168.1.0/24", "192.168.1.100", false},
+		{"ipv4 last", "192.168.1.0/24", "192.168.1.254", false},
+		{"ipv4 wide subnet", "10.0.0.0/16", "10.0.255.254", false},
+		{"ipv4 /8 beyond 2^16", "10.0.0.0/8", "10.1.0.1", false},
+		{"ipv4 /8 last", "10.0.0.0/8", "10.255.255.254", false},
+		{"ipv4 /8 broadcast", "10.0.0.0/8", "10.255.255.255", true},
+		{"ipv6", "fd00::/64", "fd00::100", false},
+		{"ipv6 beyond 2^16", "fd00::/64", "fd00::1:0:1", false},
+		{"ipv6 last of /64", "fd00::/64", "fd00::ffff:ffff:ffff:ffff", false},
+		{"ipv6 beyond 2^64", "fd00::/48", "fd00:0:0:1::1", true},
+		{"allocated", "192.168.1.0/24", "192.168.1.1", true},
+		{"network address", "192.168.1.0/24", "192.168.1.0", true},
+		{"broadcast", "192.168.1.0/24", "192.168.1.255", true},
+		{"out of subnet", "192.168.1.0/24", "192.168.2.1", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipam := &IPAM{SubnetAllocatorPath: path.Join(t.TempDir(), "subnet.json")}
+			_, subnet, _ := net.ParseCIDR(tt.subnet)
+			first, _ := ipam.Allocate(subnet)
+			ip := net.ParseIP(tt.ip)
+			err := ipam.AllocateIP(subnet, ip)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("AllocateIP() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if tt.wantErr {
+				return
+			}
+			//指定的地址不会再被分配
+			got, _ := ipam.Allocate(subnet)
+			if got.Equal(ip) || got.Equal(first) {
+				t.Errorf("Allocate() after AllocateIP got = %v", got)
+			}
+			if err := ipam.AllocateIP(subnet, ip); err == nil {
+				t.Errorf("AllocateIP() twice, want error")
+			}
+		})
+	}
+}
+
+func TestIPAMLoadLegacy(t *testing.T) {
+	allocatorPath := path.Join(t.TempDir(), "subnet.json")
+	if err := ioutil.WriteFile(allocatorPath, []byte(`{"192.168.1.0/24":"1100"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ipam := &IPAM{SubnetAllocatorPath: allocatorPath}
+	_, subnet, _ := net.ParseCIDR("192.168.1.0/24")
+	got, err := ipam.Allocate(subnet)
+	if err != nil {
+		t.Fatalf("Allocate() error = %v", err)
+	}
+	if want := net.ParseIP("192.168.1.3"); !got.Equal(want) {
+		t.Errorf("Allocate() got = %v, want %v", got, want)
+	}
+}
+
+func TestBitmapFirstClear(t *testing.T) {
+	full := func(bytes int) []byte {
+		chunk := make([]byte, bytes)
+		for i := range chunk {
+			chunk[i] = 0xff
+		}
+		return chunk
+	}
+	tests := []struct {
+		name   string
+		alloc  bitmap
+		first  uint64
+		last   uint64
+		want   uint64
+		wantOk bool
+	}{
+		{"empty", bitmap{}, 1, 254, 1, true},
+		{"partial byte", bitmap{0: {0x0f}}, 1, 254, 4, true},
+		{"full chunk", bitmap{0: full(1 << chunkBits / 8)}, 1, math.MaxUint64, 1 << chunkBits, true},
+		{"full chunks", bitmap{0: full(1 << chunkBits / 8), 1: full(1 << chunkBits / 8)}, 1, math.MaxUint64, 2 << chunkBits, true},
+		{"range full", bitmap{0: full(1)}, 0, 7, 0, false},
+		{"last offset", bitmap{}, math.MaxUint64, math.MaxUint64, math.MaxUint64, true},
+		{"last offset allocated", bitmap{math.MaxUint64 >> chunkBits: full(1 << chunkBits / 8)}, math.MaxUint64 - 1, math.MaxUint64, 0, false},
+		{"empty range", bitmap{}, 1, 0, 0, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := tt.alloc.firstClear(tt.first, tt.last)
+			if ok != tt.wantOk || (ok && got != tt.want) {
+				t.Errorf("firstClear() got = %v, %v, want %v, %v", got, ok, tt.want, tt.wantOk)
+			}
+		})
+	}
+}
+
+func TestIPAMAllocateInRange(t *testing.T) {
+	tests := []struct {
+		name    string
+		subnet  string
+		ipRange string
+		want    []string
+	}{
+		{"ipv4", "10.10.0.0/24", "10.10.0.128/30", []string{"10.10.0.128", "10.10.0.129", "10.10.0.130", "10.10.0.131", ""}},
+		{"ipv4 start of subnet", "10.10.0.0/24", "10.10.0.0/30", []string{"10.10.0.1", "10.10.0.2", "10.10.0.3", ""}},
+		{"ipv4 end of subnet", "10.10.0.0/24", "10.10.0.252/30", []string{"10.10.0.252", "10.10.0.253", "10.10.0.254", ""}},
+		{"ipv6", "fd00::/64", "fd00::100/126", []string{"fd00::100", "fd00::101", "fd00::102", "fd00::103", ""}},
+		{"ipv6 wide range", "fd00::/64", "fd00::/80", []string{"fd00::1", "fd00::2"}},
+		{"ipv4 /8 beyond 2^16", "10.0.0.0/8", "10.200.0.0/30", []string{"10.200.0.0", "10.200.0.1", "10.200.0.2", "10.200.0.3", ""}},
+		{"ipv4 /8 end of subnet", "10.0.0.0/8", "10.255.255.252/30", []string{"10.255.255.252", "10.255.255.253", "10.255.255.254", ""}},
+		{"ipv6 beyond 2^16", "fd00::/64", "fd00::5:0/127", []string{"fd00::5:0", "fd00::5:1", ""}},
+		{"ipv6 end of /64", "fd00::/64", "fd00::ffff:ffff:ffff:fffe/127", []string{"fd00::ffff:ffff:ffff:fffe", "fd00::ffff:ffff:ffff:ffff", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipam := &IPAM{SubnetAllocatorPath: path.Join(t.TempDir(), "subnet.json")}
+			_, subnet, _ := net.ParseCIDR(tt.subnet)
+			_, ipRange, _ := net.ParseCIDR(tt.ipRange)
+			for _, want := range tt.want {
+				got, err := ipam.AllocateInRange(subnet, ipRange)
+				if want == "" {
+					if err == nil {
+						t.Errorf("AllocateInRange() got = %v, want error", got)
+					}
+					return
+				}
+				if err != nil {
+					t.Fatalf("AllocateInRange() error = %v", err)
+				}
+				if !got.Equal(net.ParseIP(want)) {
+					t.Errorf("AllocateInRange() got = %v, want %v", got, want)
+				}
+			}
+		})
+	}
+}
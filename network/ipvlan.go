@@ -0,0 +1,81 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/RedDragonet/rocker/pkg/stringid"
+	"github.com/vishvananda/netlink"
+)
+
+const OptionIpvlanMode = "ipvlan_mode"
+
+var ipvlanModes = map[string]netlink.IPVlanMode{
+	"l2":  netlink.IPVLAN_MODE_L2,
+	"l3":  netlink.IPVLAN_MODE_L3,
+	"l3s": netlink.IPVLAN_MODE_L3S,
+}
+
+//ipvlan 网络，与 macvlan 类似，但所有子接口共用父网卡的 MAC 地址
+//network create --driver ipvlan --subnet 192.168.1.1/24 -o parent=eth0 -o ipvlan_mode=l2 lan
+type IpvlanNetworkDriver struct {
+}
+
+func (d *IpvlanNetworkDriver) Name() string {
+	return "ipvlan"
+}
+
+func (d *IpvlanNetworkDriver) Create(n *Network) error {
+	if _, err := getParentLink(n.Options); err != nil {
+		return err
+	}
+	if mode, ok := n.Options[OptionIpvlanMode]; ok {
+		if _, ok := ipvlanModes[mode]; !ok {
+			return fmt.Errorf("错误的 %s %s，支持 l2、l3、l3s", OptionIpvlanMode, mode)
+		}
+	}
+
+	return nil
+}
+
+func (d *IpvlanNetworkDriver) Delete(network Network) error {
+	return nil
+}
+
+//在父网卡上创建 ipvlan 子接口，由 configEndpointIpAddressAndRoute 移入容器
+func (d *IpvlanNetworkDriver) Connect(network *Network, endpoint *Endpoint) error {
+	parent, err := getParentLink(network.Options)
+	if err != nil {
+		return err
+	}
+
+	mode := netlink.IPVLAN_MODE_L2
+	if m, ok := network.Options[OptionIpvlanMode]; ok {
+		mode = ipvlanModes[m]
+	}
+
+	la := netlink.NewLinkAttrs()
+	la.Name = "cif-" + stringid.GenerateRandomID()[:7]
+	la.ParentIndex = parent.Attrs().Index
+	link := &netlink.IPVlan{
+		LinkAttrs: la,
+		Mode:      mode,
+	}
+	if err := netlink.LinkAdd(link); err != nil {
+		return fmt.Errorf("创建 ipvlan 子接口失败: %v ", err)
+	}
+	endpoint.InterfaceName = la.Name
+	return nil
+}
+
+func (d *IpvlanNetworkDriver) Disconnect(network Network, endpoint *Endpoint) error {
+	return deleteSubInterface(endpoint.InterfaceName)
+}
+
+//l3、l3s 模式的 ipvlan 网络
+func ipvlanL3(n *Network) bool {
+	if n.Driver != "ipvlan" {
+		return false
+	}
+	mode := n.Options[OptionIpvlanMode]
+	return mode == "l3" || mode == "l3s"
+}
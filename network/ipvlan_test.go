@@ -0,0 +1,80 @@
+package network
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestDefaultRoutes(t *testing.T) {
+	_, v4, _ := net.ParseCIDR("192.168.1.0/24")
+	v4.IP = net.ParseIP("192.168.1.1").To4()
+	_, v6, _ := net.ParseCIDR("fd00::/64")
+	v6.IP = net.ParseIP("fd00::1")
+
+	tests := []struct {
+		name    string
+		network *Network
+		wantGw  []string
+	}{
+		{"bridge", &Network{Driver: "bridge", IpRange: v4}, []string{"192.168.1.1"}},
+		{"bridge dual stack", &Network{Driver: "bridge", IpRange: v4, IPv6Range: v6}, []string{"192.168.1.1", "fd00::1"}},
+		{"ipvlan l2", &Network{Driver: "ipvlan", IpRange: v4, Options: map[string]string{}}, []string{"192.168.1.1"}},
+		{"ipvlan l3", &Network{Driver: "ipvlan", IpRange: v4, Options: map[string]string{OptionIpvlanMode: "l3"}}, []string{""}},
+		{"ipvlan l3s dual stack", &Network{Driver: "ipvlan", IpRange: v4, IPv6Range: v6, Options: map[string]string{OptionIpvlanMode: "l3s"}}, []string{"", ""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			routes := defaultRoutes(tt.network, 10)
+			if len(routes) != len(tt.wantGw) {
+				t.Fatalf("defaultRoutes() got %d routes, want %d", len(routes), len(tt.wantGw))
+			}
+			for i, route := range routes {
+				if route.LinkIndex != 10 {
+					t.Errorf("defaultRoutes() LinkIndex = %d, want 10", route.LinkIndex)
+				}
+				if ones, _ := route.Dst.Mask.Size(); ones != 0 {
+					t.Errorf("defaultRoutes() Dst = %v, want default", route.Dst)
+				}
+				if tt.wantGw[i] == "" {
+					if route.Gw != nil || route.Scope != netlink.SCOPE_LINK {
+						t.Errorf("defaultRoutes() Gw = %v Scope = %v, want device route", route.Gw, route.Scope)
+					}
+					continue
+				}
+				if !route.Gw.Equal(net.ParseIP(tt.wantGw[i])) {
+					t.Errorf("defaultRoutes() Gw = %v, want %v", route.Gw, tt.wantGw[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCheckPortMapping(t *testing.T) {
+	saved := networks
+	t.Cleanup(func() { networks = saved })
+	networks = map[string]*Network{
+		"br":      {Name: "br", Driver: "bridge"},
+		"mv":      {Name: "mv", Driver: "macvlan"},
+		"iv":      {Name: "iv", Driver: "ipvlan"},
+		"overlay": {Name: "overlay", Driver: "overlay"},
+	}
+	tests := []struct {
+		network string
+		wantErr bool
+	}{
+		{"br", false},
+		{"overlay", false},
+		{"mv", true},
+		{"iv", true},
+		{"missing", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.network, func(t *testing.T) {
+			if err := CheckPortMapping(tt.network); (err != nil) != tt.wantErr {
+				t.Errorf("CheckPortMapping() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
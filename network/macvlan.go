@@ -0,0 +1,106 @@
+package network
+
+import (
+	"fmt"
+
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"github.com/RedDragonet/rocker/pkg/stringid"
+	"github.com/vishvananda/netlink"
+)
+
+const (
+	//宿主机上的父网卡，容器的子接口创建在父网卡上
+	OptionParent      = "parent"
+	OptionMacvlanMode = "macvlan_mode"
+)
+
+var macvlanModes = map[string]netlink.MacvlanMode{
+	"bridge":   netlink.MACVLAN_MODE_BRIDGE,
+	"private":  netlink.MACVLAN_MODE_PRIVATE,
+	"vepa":     netlink.MACVLAN_MODE_VEPA,
+	"passthru": netlink.MACVLAN_MODE_PASSTHRU,
+}
+
+//macvlan 网络，容器在父网卡所在的二层网络中拥有独立的 MAC 与 IP 地址
+//network create --driver macvlan --subnet 192.168.1.1/24 -o parent=eth0 lan
+type MacvlanNetworkDriver struct {
+}
+
+func (d *MacvlanNetworkDriver) Name() string {
+	return "macvlan"
+}
+
+func (d *MacvlanNetworkDriver) Create(n *Network) error {
+	if _, err := getParentLink(n.Options); err != nil {
+		return err
+	}
+	if mode, ok := n.Options[OptionMacvlanMode]; ok {
+		if _, ok := macvlanModes[mode]; !ok {
+			return fmt.Errorf("错误的 %s %s，支持 bridge、private、vepa、passthru", OptionMacvlanMode, mode)
+		}
+	}
+
+	return nil
+}
+
+//macvlan 网络没有宿主机上的设备，父网卡不属于网络
+func (d *MacvlanNetworkDriver) Delete(network Network) error {
+	return nil
+}
+
+//在父网卡上创建 macvlan 子接口，由 configEndpointIpAddressAndRoute 移入容器
+func (d *MacvlanNetworkDriver) Connect(network *Network, endpoint *Endpoint) error {
+	parent, err := getParentLink(network.Options)
+	if err != nil {
+		return err
+	}
+
+	mode := netlink.MACVLAN_MODE_BRIDGE
+	if m, ok := network.Options[OptionMacvlanMode]; ok {
+		mode = macvlanModes[m]
+	}
+
+	la := netlink.NewLinkAttrs()
+	la.Name = "cif-" + stringid.GenerateRandomID()[:7]
+	la.ParentIndex = parent.Attrs().Index
+	link := &netlink.Macvlan{
+		LinkAttrs: la,
+		Mode:      mode,
+	}
+	if err := netlink.LinkAdd(link); err != nil {
+		return fmt.Errorf("创建 macvlan 子接口失败: %v ", err)
+	}
+	endpoint.InterfaceName = la.Name
+	return nil
+}
+
+//子接口已移入容器，随容器 network namespace 销毁，只需处理尚未移入容器的子接口
+func (d *MacvlanNetworkDriver) Disconnect(network Network, endpoint *Endpoint) error {
+	return deleteSubInterface(endpoint.InterfaceName)
+}
+
+//获取 -o parent 指定的父网卡
+func getParentLink(options map[string]string) (netlink.Link, error) {
+	parentName := options[OptionParent]
+	if parentName == "" {
+		return nil, fmt.Errorf("缺少参数 -o %s=<网卡>", OptionParent)
+	}
+	parent, err := netlink.LinkByName(parentName)
+	if err != nil {
+		return nil, fmt.Errorf("父网卡 %s 不存在 %v", parentName, err)
+	}
+	return parent, nil
+}
+
+//删除宿主机上的子接口
+func deleteSubInterface(name string) error {
+	link, err := netlink.LinkByName(name)
+	if err != nil {
+		log.Debugf("子接口 %s 不存在，跳过 %v", name, err)
+		return nil
+	}
+	if err := netlink.LinkDel(link); err != nil {
+		return fmt.Errorf("删除子接口 %s 失败: %v ", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package network
+
+import (
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+//创建测试用的 network namespace，测试结束后销毁
+func setupTestNetns(t *testing.T) netns.NsHandle {
+	if os.Geteuid() != 0 {
+		t.Skip("需要 root 权限")
+	}
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		t.Skipf("获取 network namespace 失败 %v", err)
+	}
+	defer origin.Close()
+	//netns.New 会切换当前线程的 network namespace
+	ns, err := netns.New()
+	if err != nil {
+		t.Skipf("创建 network namespace 失败 %v", err)
+	}
+	if err := netns.Set(origin); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ns.Close() })
+	return ns
+}
+
+//当前 goroutine 进入测试用的 network namespace，测试结束后返回
+//子测试运行在不同的 goroutine 中，需要分别进入
+func enterTestNetns(t *testing.T, ns netns.NsHandle) {
+	runtime.LockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		t.Fatal(err)
+	}
+	if err := netns.Set(ns); err != nil {
+		origin.Close()
+		runtime.UnlockOSThread()
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		netns.Set(origin)
+		origin.Close()
+		runtime.UnlockOSThread()
+	})
+}
+
+//在测试用的 network namespace 中创建 dummy 网卡作为父网卡
+func setupDummyParent(t *testing.T, ns netns.NsHandle, name string) netlink.Link {
+	enterTestNetns(t, ns)
+	if err := netlink.LinkAdd(&netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: name}}); err != nil {
+		t.Skipf("内核不支持 dummy 网卡 %v", err)
+	}
+	parent, err := netlink.LinkByName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := netlink.LinkSetUp(parent); err != nil {
+		t.Fatal(err)
+	}
+	return parent
+}
+
+func TestSubInterfaceDrivers(t *testing.T) {
+	ns := setupTestNetns(t)
+	parent := setupDummyParent(t, ns, "rocker-dummy0")
+
+	tests := []struct {
+		name     string
+		driver   NetworkDriver
+		options  map[string]string
+		linkType string
+		wantErr  bool
+	}{
+		{"macvlan", &MacvlanNetworkDriver{}, map[string]string{OptionParent: "rocker-dummy0"}, "macvlan", false},
+		{"macvlan private", &MacvlanNetworkDriver{}, map[string]string{OptionParent: "rocker-dummy0", OptionMacvlanMode: "private"}, "macvlan", false},
+		{"macvlan bad mode", &MacvlanNetworkDriver{}, map[string]string{OptionParent: "rocker-dummy0", OptionMacvlanMode: "l2"}, "", true},
+		{"macvlan missing parent", &MacvlanNetworkDriver{}, map[string]string{}, "", true},
+		{"macvlan parent not found", &MacvlanNetworkDriver{}, map[string]string{OptionParent: "rocker-none0"}, "", true},
+		{"ipvlan", &IpvlanNetworkDriver{}, map[string]string{OptionParent: "rocker-dummy0"}, "ipvlan", false},
+		{"ipvlan l3", &IpvlanNetworkDriver{}, map[string]string{OptionParent: "rocker-dummy0", OptionIpvlanMode: "l3"}, "ipvlan", false},
+		{"ipvlan bad mode", &IpvlanNetworkDriver{}, map[string]string{OptionParent: "rocker-dummy0", OptionIpvlanMode: "bridge"}, "", true},
+		{"ipvlan missing parent", &IpvlanNetworkDriver{}, map[string]string{}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enterTestNetns(t, ns)
+			n := &Network{Name: "test", Driver: tt.driver.Name(), Options: tt.options}
+			err := tt.driver.Create(n)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Create() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			ep := &Endpoint{Network: n}
+			if err := tt.driver.Connect(n, ep); err != nil {
+				t.Skipf("内核不支持 %s %v", tt.linkType, err)
+			}
+			link, err := netlink.LinkByName(ep.InterfaceName)
+			if err != nil {
+				t.Fatalf("Connect() 子接口 %s 不存在 %v", ep.InterfaceName, err)
+			}
+			if link.Type() != tt.linkType || link.Attrs().ParentIndex != parent.Attrs().Index {
+				t.Errorf("Connect() 子接口类型 %s 父网卡 %d, want %s %d", link.Type(), link.Attrs().ParentIndex, tt.linkType, parent.Attrs().Index)
+			}
+
+			if err := tt.driver.Disconnect(*n, ep); err != nil {
+				t.Fatalf("Disconnect() error = %v", err)
+			}
+			if _, err := netlink.LinkByName(ep.InterfaceName); err == nil {
+				t.Errorf("Disconnect() 子接口 %s 未删除", ep.InterfaceName)
+			}
+			//子接口已移入容器时 Disconnect 不报错
+			if err := tt.driver.Disconnect(*n, ep); err != nil {
+				t.Errorf("Disconnect() twice error = %v", err)
+			}
+		})
+	}
+}
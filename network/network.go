@@ -31,12 +31,75 @@ type Endpoint struct {
 	MacAddress  net.HardwareAddr `json:"mac"`
 	Network     *Network
 	PortMapping []string
+	//双栈网络中的 IPv6 地址
+	IPv6Address net.IP `json:"ip6"`
+
+	//移入容器 network namespace 的网卡，bridge 为 veth 的另一端，macvlan、ipvlan 为子接口
+	InterfaceName string
 }
 
+//IpRange 为网络的第一个网段，只有 IPv6 网段时为 IPv6 网段，IP 字段为网关地址
 type Network struct {
 	Name    string
 	IpRange *net.IPNet
 	Driver  string
+	//驱动参数，network create -o 指定，如 macvlan 的 parent
+	Options map[string]string
+	//双栈网络的 IPv6 网段
+	IPv6Range *net.IPNet
+	//--ip-range 指定的容器地址范围，为空时在整个网段中分配
+	AllocRange     *net.IPNet
+	IPv6AllocRange *net.IPNet
+}
+
+//创建网络的参数
+type CreateConfig struct {
+	Driver string
+	//最多一个 IPv4 网段与一个 IPv6 网段，IPv6 网段需要指定 IPv6
+	Subnets []string
+	//每个网段的网关地址，未指定时使用网段中第一个可用的地址
+	Gateways []string
+	//每个网段中分配给容器的地址范围
+	IPRanges []string
+	IPv6     bool
+	//驱动参数，如 macvlan 的 parent
+	Options map[string]string
+}
+
+//网络的所有网段
+func (nw *Network) ipRanges() []*net.IPNet {
+	ranges := []*net.IPNet{nw.IpRange}
+	if nw.IPv6Range != nil {
+		ranges = append(ranges, nw.IPv6Range)
+	}
+	return ranges
+}
+
+//网段中分配给容器的地址范围，未指定时返回 nil
+func (nw *Network) allocRangeFor(ipRange *net.IPNet) *net.IPNet {
+	if ipRange == nw.IPv6Range {
+		return nw.IPv6AllocRange
+	}
+	return nw.AllocRange
+}
+
+//IP地址所在的网段
+func (nw *Network) ipRangeFor(ip net.IP) *net.IPNet {
+	for _, ipRange := range nw.ipRanges() {
+		if ipRange.Contains(ip) {
+			return ipRange
+		}
+	}
+	return nil
+}
+
+//端点的所有IP地址
+func (ep *Endpoint) ipAddresses() []net.IP {
+	ips := []net.IP{ep.IPAddress}
+	if ep.IPv6Address != nil {
+		ips = append(ips, ep.IPv6Address)
+	}
+	return ips
 }
 
 func (nw *Network) dump(dumpPath string) error {
@@ -83,18 +146,12 @@ func (nw *Network) remove(dumpPath string) error {
 }
 
 func (nw *Network) load(dumpPath string) error {
-	nwConfigFile, err := os.Open(dumpPath)
-	defer nwConfigFile.Close()
-	if err != nil {
-		return err
-	}
-	nwJson := make([]byte, 2000)
-	n, err := nwConfigFile.Read(nwJson)
+	nwJson, err := ioutil.ReadFile(dumpPath)
 	if err != nil {
 		return err
 	}
 
-	err = json.Unmarshal(nwJson[:n], nw)
+	err = json.Unmarshal(nwJson, nw)
 	if err != nil {
 		log.Errorf("Error load nw info", err)
 		return err
@@ -127,6 +184,12 @@ func Init() error {
 
 	var bridgeDriver = BridgeNetworkDriver{}
 	drivers[bridgeDriver.Name()] = &bridgeDriver
+	var macvlanDriver = MacvlanNetworkDriver{}
+	drivers[macvlanDriver.Name()] = &macvlanDriver
+	var ipvlanDriver = IpvlanNetworkDriver{}
+	drivers[ipvlanDriver.Name()] = &ipvlanDriver
+	var overlayDriver = OverlayNetworkDriver{}
+	drivers[overlayDriver.Name()] = &overlayDriver
 
 	if _, err := os.Stat(defaultNetworkPath); err != nil {
 		if os.IsNotExist(err) {
@@ -156,29 +219,211 @@ func Init() error {
 	return nil
 }
 
-func CreateNetwork(driver, subnet, name string) error {
-	_, cidr, _ := net.ParseCIDR(subnet)
-	ip, err := ipAllocator.Allocate(cidr)
+//创建网络，指定 IPv6 时可以同时指定一个 IPv4 网段与一个 IPv6 网段组成双栈网络
+func CreateNetwork(name string, config CreateConfig) error {
+	if name == "" {
+		return fmt.Errorf("缺少网络名称")
+	}
+	if _, ok := networks[name]; ok {
+		return fmt.Errorf("网络 %s 已经存在", name)
+	}
+	d, ok := drivers[config.Driver]
+	if !ok {
+		return fmt.Errorf("不支持的网络驱动 %s", config.Driver)
+	}
+
+	ipRange, ipv6Range, err := parseSubnets(config.Subnets, config.IPv6)
+	if err != nil {
+		return err
+	}
+	nw := &Network{
+		Name:      name,
+		IpRange:   ipRange,
+		Driver:    config.Driver,
+		Options:   config.Options,
+		IPv6Range: ipv6Range,
+	}
+	if err := checkOverlap(nw); err != nil {
+		return err
+	}
+	gateways, err := parseGateways(nw, config.Gateways)
 	if err != nil {
 		return err
 	}
-	cidr.IP = ip
+	if err := parseIPRanges(nw, config.IPRanges); err != nil {
+		return err
+	}
+	if nw.Driver == "overlay" {
+		if err := checkOverlayRanges(nw, gateways); err != nil {
+			return err
+		}
+	}
 
-	nw, err := drivers[driver].Create(cidr.String(), name)
+	if config.IPv6 {
+		forwarding, err := ioutil.ReadFile("/proc/sys/net/ipv6/conf/all/forwarding")
+		if err != nil {
+			return err
+		}
+		if forwarding[0] != '1' {
+			log.Errorf("建议按照如下命令设置")
+			log.Errorf("sysctl -w net.ipv6.conf.all.forwarding=1")
+			return fmt.Errorf("IPv6 forwarding 参数未配置正确")
+		}
+	}
+
+	allocated, err := allocateGateways(nw, gateways)
 	if err != nil {
 		return err
 	}
 
+	if err := d.Create(nw); err != nil {
+		releaseGateways(allocated)
+		return err
+	}
+
 	return nw.dump(defaultNetworkPath)
 }
 
+//解析 --subnet，最多一个 IPv4 网段与一个 IPv6 网段，IPv6 网段需要指定 --ipv6
+func parseSubnets(subnets []string, ipv6 bool) (ipRange *net.IPNet, ipv6Range *net.IPNet, err error) {
+	var v4, v6 *net.IPNet
+	for _, subnet := range subnets {
+		_, cidr, err := net.ParseCIDR(subnet)
+		if err != nil {
+			return nil, nil, fmt.Errorf("错误的网段 %s", subnet)
+		}
+		if cidr.IP.To4() != nil {
+			if v4 != nil {
+				return nil, nil, fmt.Errorf("只能指定一个 IPv4 网段")
+			}
+			v4 = cidr
+		} else {
+			if v6 != nil {
+				return nil, nil, fmt.Errorf("只能指定一个 IPv6 网段")
+			}
+			v6 = cidr
+		}
+	}
+
+	switch {
+	case v4 == nil && v6 == nil:
+		return nil, nil, fmt.Errorf("缺少参数 --subnet")
+	case v6 != nil && !ipv6:
+		return nil, nil, fmt.Errorf("IPv6 网段 %s 需要指定 --ipv6", v6.String())
+	case v6 == nil && ipv6:
+		return nil, nil, fmt.Errorf("--ipv6 需要指定 IPv6 网段")
+	case v4 == nil:
+		return v6, nil, nil
+	}
+	return v4, v6, nil
+}
+
+//检查网段是否与已创建网络的网段重叠
+func checkOverlap(nw *Network) error {
+	for _, other := range networks {
+		if other.IpRange == nil {
+			continue
+		}
+		for _, a := range nw.ipRanges() {
+			for _, b := range other.ipRanges() {
+				if a.Contains(networkOf(b).IP) || b.Contains(networkOf(a).IP) {
+					return fmt.Errorf("网段 %s 与网络 %s 的网段 %s 重叠", a.String(), other.Name, networkOf(b).String())
+				}
+			}
+		}
+	}
+	return nil
+}
+
+//解析 --gateway，每个网段最多指定一个网关
+func parseGateways(nw *Network, gateways []string) (map[*net.IPNet]net.IP, error) {
+	result := map[*net.IPNet]net.IP{}
+	for _, gateway := range gateways {
+		ip := net.ParseIP(gateway)
+		if ip == nil {
+			return nil, fmt.Errorf("错误的网关地址 %s", gateway)
+		}
+		ipRange := nw.ipRangeFor(ip)
+		if ipRange == nil {
+			return nil, fmt.Errorf("网关地址 %s 不在网段中", gateway)
+		}
+		if _, ok := result[ipRange]; ok {
+			return nil, fmt.Errorf("网段 %s 只能指定一个网关", ipRange.String())
+		}
+		result[ipRange] = ip
+	}
+	return result, nil
+}
+
+//解析 --ip-range，地址范围需在网段内，每个网段最多指定一个
+func parseIPRanges(nw *Network, ipRanges []string) error {
+	for _, r := range ipRanges {
+		_, allocRange, err := net.ParseCIDR(r)
+		if err != nil {
+			return fmt.Errorf("错误的地址范围 %s", r)
+		}
+		ipRange := nw.ipRangeFor(allocRange.IP)
+		if ipRange == nil {
+			return fmt.Errorf("地址范围 %s 不在网段中", r)
+		}
+		rangeOnes, _ := allocRange.Mask.Size()
+		subnetOnes, _ := ipRange.Mask.Size()
+		if rangeOnes < subnetOnes {
+			return fmt.Errorf("地址范围 %s 超出网段 %s", r, ipRange.String())
+		}
+		if nw.allocRangeFor(ipRange) != nil {
+			return fmt.Errorf("网段 %s 只能指定一个地址范围", ipRange.String())
+		}
+		if ipRange == nw.IPv6Range {
+			nw.IPv6AllocRange = allocRange
+		} else {
+			nw.AllocRange = allocRange
+		}
+	}
+	return nil
+}
+
+//分配网关地址，未指定时使用网段中第一个可用的地址
+//overlay 网络各主机共用网段，使用本机 --ip-range 中第一个可用的地址
+func allocateGateways(nw *Network, gateways map[*net.IPNet]net.IP) ([]*net.IPNet, error) {
+	var allocated []*net.IPNet
+	for _, cidr := range nw.ipRanges() {
+		var err error
+		ip := gateways[cidr]
+		if ip != nil {
+			err = ipAllocator.AllocateIP(cidr, ip)
+		} else if nw.Driver == "overlay" {
+			ip, err = ipAllocator.AllocateInRange(cidr, nw.allocRangeFor(cidr))
+		} else {
+			ip, err = ipAllocator.Allocate(cidr)
+		}
+		if err != nil {
+			releaseGateways(allocated)
+			return nil, fmt.Errorf("分配网关地址失败 %v", err)
+		}
+		cidr.IP = ip
+		allocated = append(allocated, cidr)
+	}
+	return allocated, nil
+}
+
+func releaseGateways(ipRanges []*net.IPNet) {
+	for _, ipRange := range ipRanges {
+		ipAllocator.Release(ipRange, ipRange.IP)
+	}
+}
+
 func ListNetwork() {
 	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
 	fmt.Fprint(w, "NAME\tIpRange\tDriver\n")
 	for _, nw := range networks {
+		ipRanges := make([]string, 0, 2)
+		for _, ipRange := range nw.ipRanges() {
+			ipRanges = append(ipRanges, ipRange.String())
+		}
 		fmt.Fprintf(w, "%s\t%s\t%s\n",
 			nw.Name,
-			nw.IpRange.String(),
+			strings.Join(ipRanges, ","),
 			nw.Driver,
 		)
 	}
@@ -194,8 +439,10 @@ func DeleteNetwork(networkName string) error {
 		return fmt.Errorf("未找到对应的网络配置: %s", networkName)
 	}
 
-	if err := ipAllocator.Release(nw.IpRange, &nw.IpRange.IP); err != nil {
-		return fmt.Errorf("释放 ip 地址 %s 失败 %v", nw.IpRange.IP.String(), err)
+	for _, ipRange := range nw.ipRanges() {
+		if err := ipAllocator.Release(ipRange, ipRange.IP); err != nil {
+			return fmt.Errorf("释放 ip 地址 %s 失败 %v", ipRange.IP.String(), err)
+		}
 	}
 
 	if err := drivers[nw.Driver].Delete(*nw); err != nil {
@@ -205,44 +452,280 @@ func DeleteNetwork(networkName string) error {
 	return nw.remove(defaultNetworkPath)
 }
 
-func Connect(networkName string, cinfo *container.ContainerInfo) error {
+//将容器连接到网络，并记录容器的网络端点
+//primary 为容器的主网络，配置默认路由与端口映射
+func Connect(networkName string, cinfo *container.ContainerInfo, primary bool) error {
 	network, ok := networks[networkName]
 	if !ok {
 		return fmt.Errorf("未找到对应的网络配置: %s", networkName)
 	}
+	if cinfo.Endpoint(networkName) != nil {
+		return fmt.Errorf("容器 %s 已连接网络 %s", cinfo.Name, networkName)
+	}
 
-	// 分配容器IP地址
-	ip, err := ipAllocator.Allocate(network.IpRange)
+	// 分配容器IP地址，主网络使用 --ip 指定的地址
+	var staticIP net.IP
+	if primary {
+		staticIP = cinfo.Config.StaticIP
+	}
+	ips, err := allocateIPs(network, staticIP)
 	if err != nil {
 		return err
 	}
 
 	// 创建网络端点
 	ep := &Endpoint{
-		ID:          fmt.Sprintf("%s-%s", cinfo.ID, networkName),
-		IPAddress:   ip,
-		Network:     network,
-		PortMapping: cinfo.Config.PortMapping,
+		ID:        fmt.Sprintf("%s-%s", cinfo.ID, networkName),
+		IPAddress: ips[0],
+		Network:   network,
+	}
+	if len(ips) > 1 {
+		ep.IPv6Address = ips[1]
+	}
+	if primary {
+		ep.PortMapping = cinfo.Config.PortMapping
 	}
 	// 调用网络驱动挂载和配置网络端点
 	if err = drivers[network.Driver].Connect(network, ep); err != nil {
+		for _, ip := range ep.ipAddresses() {
+			ReleaseIP(networkName, ip)
+		}
 		return err
 	}
 
-	// 这里 veth 的另一端已经连接到 Bridge 了
-	// ep变量中的 Device 字段也已经被赋值
+	// 先记录端点，后续配置失败时可以通过端点释放IP地址与 veth
+	epInfo := container.EndpointInfo{
+		ID:          ep.ID,
+		Network:     networkName,
+		IPAddress:   ep.IPAddress,
+		IPv6Address: ep.IPv6Address,
+		VethName:    ep.Device.Name,
+		PeerName:    ep.InterfaceName,
+		PortMapping: ep.PortMapping,
+	}
+	if err = container.RecordContainerEndpoint(cinfo.ID, epInfo); err != nil {
+		return err
+	}
+	cinfo.Endpoints = append(cinfo.Endpoints, epInfo)
+
+	// 这里 veth 的另一端已经连接到 Bridge 了，macvlan、ipvlan 子接口已经创建
+	// ep变量中的 InterfaceName 字段也已经被赋值
 	// 到容器的namespace配置容器网络设备IP地址
-	if err = configEndpointIpAddressAndRoute(ep, cinfo); err != nil {
+	if err = configEndpointIpAddressAndRoute(ep, cinfo, primary); err != nil {
 		return err
 	}
 
-	if err = container.RecordContainerIP(cinfo.ID, ip); err != nil {
+	epInfo.MacAddress = ep.MacAddress.String()
+	if err = container.RecordContainerEndpoint(cinfo.ID, epInfo); err != nil {
 		return err
 	}
+	*cinfo.Endpoint(networkName) = epInfo
 
 	return configPortMapping(ep, cinfo)
 }
 
+//为端点分配网络中每个网段的IP地址，staticIP 所在的网段使用指定的地址
+func allocateIPs(network *Network, staticIP net.IP) ([]net.IP, error) {
+	if staticIP != nil && network.ipRangeFor(staticIP) == nil {
+		return nil, fmt.Errorf("ip 地址 %s 不在网络 %s 中", staticIP.String(), network.Name)
+	}
+
+	var ips []net.IP
+	for _, ipRange := range network.ipRanges() {
+		var ip net.IP
+		var err error
+		if staticIP != nil && ipRange.Contains(staticIP) {
+			ip, err = staticIP, ipAllocator.AllocateIP(ipRange, staticIP)
+		} else {
+			ip, err = ipAllocator.AllocateInRange(ipRange, network.allocRangeFor(ipRange))
+		}
+		if err != nil {
+			for _, ip := range ips {
+				ReleaseIP(network.Name, ip)
+			}
+			return nil, err
+		}
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+//检查 --ip 指定的地址是否在网络中
+func CheckIP(networkName string, ip net.IP) error {
+	network, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("未找到对应的网络配置: %s", networkName)
+	}
+	if network.ipRangeFor(ip) == nil {
+		return fmt.Errorf("ip 地址 %s 不在网络 %s 中", ip.String(), networkName)
+	}
+	return nil
+}
+
+//检查网络是否支持端口映射
+//macvlan、ipvlan 的容器直接接入父网卡所在的二层网络，宿主机无法经父网卡转发到容器，DNAT 规则无效
+func CheckPortMapping(networkName string) error {
+	network, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("未找到对应的网络配置: %s", networkName)
+	}
+	switch network.Driver {
+	case "macvlan", "ipvlan":
+		return fmt.Errorf("网络 %s 的驱动 %s 不支持端口映射", networkName, network.Driver)
+	}
+	return nil
+}
+
+//断开容器与网络的连接，释放端点并删除端点记录
+func Disconnect(networkName string, cinfo *container.ContainerInfo) error {
+	epInfo := cinfo.Endpoint(networkName)
+	if epInfo == nil {
+		return fmt.Errorf("容器 %s 未连接网络 %s", cinfo.Name, networkName)
+	}
+	//运行中的容器先删除容器中的网卡，veth 的另一端随之删除
+	if cinfo.State.Running && cinfo.State.Pid != 0 {
+		if err := deleteContainerInterface(cinfo, epInfo.PeerName); err != nil {
+			log.Warnf("删除容器 %s 网卡 %s 失败 %v", cinfo.Name, epInfo.PeerName, err)
+		}
+	}
+	if err := ReleaseEndpoint(epInfo); err != nil {
+		return err
+	}
+	if err := container.RemoveContainerEndpoint(cinfo.ID, networkName); err != nil {
+		return err
+	}
+	endpoints := cinfo.Endpoints[:0]
+	for _, ep := range cinfo.Endpoints {
+		if ep.Network != networkName {
+			endpoints = append(endpoints, ep)
+		}
+	}
+	cinfo.Endpoints = endpoints
+	return nil
+}
+
+//网络是否已经创建
+func HasNetwork(networkName string) bool {
+	_, ok := networks[networkName]
+	return ok
+}
+
+//释放容器在网络中分配的IP地址
+func ReleaseIP(networkName string, ip net.IP) error {
+	network, ok := networks[networkName]
+	if !ok {
+		return fmt.Errorf("未找到对应的网络配置: %s", networkName)
+	}
+
+	ipRange := network.ipRangeFor(ip)
+	if ipRange == nil {
+		return fmt.Errorf("ip 地址 %s 不在网络 %s 中", ip.String(), networkName)
+	}
+	return ipAllocator.Release(ipRange, ip)
+}
+
+//释放容器在网络中的端点，删除 veth 与端点的端口映射规则并归还IP地址
+//断开网络与删除容器共用
+func ReleaseEndpoint(epInfo *container.EndpointInfo) error {
+	network, ok := networks[epInfo.Network]
+	if !ok {
+		return fmt.Errorf("未找到对应的网络配置: %s", epInfo.Network)
+	}
+	ep := &Endpoint{
+		ID: epInfo.ID,
+		Device: netlink.Veth{
+			LinkAttrs: netlink.LinkAttrs{Name: epInfo.VethName},
+			PeerName:  epInfo.PeerName,
+		},
+		InterfaceName: epInfo.PeerName,
+		IPAddress:     epInfo.IPAddress,
+		IPv6Address:   epInfo.IPv6Address,
+		Network:       network,
+		PortMapping:   epInfo.PortMapping,
+	}
+	if err := drivers[network.Driver].Disconnect(*network, ep); err != nil {
+		return err
+	}
+	cleanPortMapping(ep)
+	for _, ip := range ep.ipAddresses() {
+		if err := ReleaseIP(epInfo.Network, ip); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//释放旧版本创建的容器的IP地址与端口映射，旧容器没有记录端点，只记录了IP地址
+//veth 随容器的 network namespace 一起删除，网络已删除时只清理端口映射
+func ReleaseLegacyEndpoint(cinfo *container.ContainerInfo) error {
+	ep := &Endpoint{
+		IPAddress:   cinfo.Config.LegacyIP,
+		PortMapping: cinfo.Config.PortMapping,
+	}
+	cleanPortMapping(ep)
+	for name, nw := range networks {
+		if nw.ipRangeFor(ep.IPAddress) != nil {
+			return ReleaseIP(name, ep.IPAddress)
+		}
+	}
+	log.Warnf("ip 地址 %s 不在任何网络中", ep.IPAddress.String())
+	return nil
+}
+
+//启动容器 network namespace 中的回环网卡，--net none 使用
+func SetupLoopback(cinfo *container.ContainerInfo) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origns, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("获取当前的 namespace 失败 %v", err)
+	}
+	defer origns.Close()
+
+	containerns, err := netns.GetFromPid(cinfo.State.Pid)
+	if err != nil {
+		return fmt.Errorf("获取容器 %s 的 network namespace 失败 %v", cinfo.Name, err)
+	}
+	defer containerns.Close()
+
+	if err := netns.Set(containerns); err != nil {
+		return fmt.Errorf("set netns 失败 %v", err)
+	}
+	defer netns.Set(origns)
+
+	return setInterfaceUP("lo")
+}
+
+//删除容器 network namespace 中的网卡
+func deleteContainerInterface(cinfo *container.ContainerInfo, interfaceName string) error {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	origns, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("获取当前的 namespace 失败 %v", err)
+	}
+	defer origns.Close()
+
+	containerns, err := netns.GetFromPid(cinfo.State.Pid)
+	if err != nil {
+		return fmt.Errorf("获取容器 %s 的 network namespace 失败 %v", cinfo.Name, err)
+	}
+	defer containerns.Close()
+
+	if err := netns.Set(containerns); err != nil {
+		return fmt.Errorf("set netns 失败 %v", err)
+	}
+	defer netns.Set(origns)
+
+	link, err := netlink.LinkByName(interfaceName)
+	if err != nil {
+		return nil
+	}
+	return netlink.LinkDel(link)
+}
+
 func enterContainerNetns(enLink *netlink.Link, cinfo *container.ContainerInfo) func() {
 	f, err := os.OpenFile(fmt.Sprintf("/proc/%d/ns/net", cinfo.State.Pid), os.O_RDONLY, 0)
 	if err != nil {
@@ -275,13 +758,14 @@ func enterContainerNetns(enLink *netlink.Link, cinfo *container.ContainerInfo) f
 	}
 }
 
-//配置端点IP地址和路由
-func configEndpointIpAddressAndRoute(ep *Endpoint, cinfo *container.ContainerInfo) error {
-	//找到 Veth 的另一端
-	peerLink, err := netlink.LinkByName(ep.Device.PeerName)
+//配置端点IP地址和路由，只有主网络配置默认路由
+func configEndpointIpAddressAndRoute(ep *Endpoint, cinfo *container.ContainerInfo, defaultRoute bool) error {
+	//找到移入容器的网卡
+	peerLink, err := netlink.LinkByName(ep.InterfaceName)
 	if err != nil {
 		return fmt.Errorf("fail config endpoint: %v", err)
 	}
+	ep.MacAddress = peerLink.Attrs().HardwareAddr
 
 	/*****
 		以下的操作都为 容器的 network namespace  中
@@ -289,16 +773,17 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, cinfo *container.ContainerInf
 
 	defer enterContainerNetns(&peerLink, cinfo)()
 
-	interfaceIP := *ep.Network.IpRange
-	interfaceIP.IP = ep.IPAddress
-
-	//配置 veth ip
-	if err = setInterfaceIP(ep.Device.PeerName, interfaceIP.String()); err != nil {
-		return fmt.Errorf("%v,%s", ep.Network, err)
+	//配置 veth ip，双栈网络同时配置 IPv4 与 IPv6 地址
+	for _, ip := range ep.ipAddresses() {
+		interfaceIP := *ep.Network.ipRangeFor(ip)
+		interfaceIP.IP = ip
+		if err = setInterfaceIP(ep.InterfaceName, interfaceIP.String()); err != nil {
+			return fmt.Errorf("%v,%s", ep.Network, err)
+		}
 	}
 
 	// 启动 veth
-	if err = setInterfaceUP(ep.Device.PeerName); err != nil {
+	if err = setInterfaceUP(ep.InterfaceName); err != nil {
 		return err
 	}
 
@@ -307,17 +792,14 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, cinfo *container.ContainerInf
 		return err
 	}
 
-	_, cidr, _ := net.ParseCIDR("0.0.0.0/0")
-
-	//配置路由
-	defaultRoute := &netlink.Route{
-		LinkIndex: peerLink.Attrs().Index, //绑定在 namespace 中的 veth
-		Gw:        ep.Network.IpRange.IP,  // Bridge ip 地址
-		Dst:       cidr,                   // default
+	if !defaultRoute {
+		return nil
 	}
 
-	if err = netlink.RouteAdd(defaultRoute); err != nil {
-		return err
+	for _, route := range defaultRoutes(ep.Network, peerLink.Attrs().Index) {
+		if err = netlink.RouteAdd(route); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -327,6 +809,31 @@ func configEndpointIpAddressAndRoute(ep *Endpoint, cinfo *container.ContainerInf
 	*****/
 }
 
+//容器的默认路由，双栈网络每个网段一条
+func defaultRoutes(n *Network, linkIndex int) []*netlink.Route {
+	routes := make([]*netlink.Route, 0, 2)
+	for _, ipRange := range n.ipRanges() {
+		defaultDst := "0.0.0.0/0"
+		if ipRange.IP.To4() == nil {
+			defaultDst = "::/0"
+		}
+		_, cidr, _ := net.ParseCIDR(defaultDst)
+
+		route := &netlink.Route{
+			LinkIndex: linkIndex,  //绑定在 namespace 中的 veth
+			Gw:        ipRange.IP, // Bridge ip 地址
+			Dst:       cidr,       // default
+		}
+		//ipvlan l3 模式由父网卡所在的宿主机路由，子接口不处理 ARP/NDP，默认路由直接绑定子接口
+		if ipvlanL3(n) {
+			route.Gw = nil
+			route.Scope = netlink.SCOPE_LINK
+		}
+		routes = append(routes, route)
+	}
+	return routes
+}
+
 func configPortMapping(ep *Endpoint, cinfo *container.ContainerInfo) error {
 	for _, pm := range ep.PortMapping {
 		portMapping := strings.Split(pm, ":")
@@ -335,44 +842,74 @@ func configPortMapping(ep *Endpoint, cinfo *container.ContainerInfo) error {
 			continue
 		}
 
-		//判断端口占用
-		iptablesCmd := fmt.Sprintf("-t nat -L PREROUTING -nv")
-		cmd := exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
-		//err := cmd.Run()
-		output, err := cmd.Output()
-		if err != nil {
-			log.Errorf("iptables 查询失败, %s %s %v", iptablesCmd, output, err)
-			continue
-		}
-
-		if strings.Index(string(output), fmt.Sprintf("dpt:%s", portMapping[0])) != -1 {
-			log.Errorf("端口 %s 已经被占用", portMapping[0])
-			return fmt.Errorf("端口 %s 已经被占用", portMapping[0])
+		//双栈网络同时映射到容器的 IPv4 与 IPv6 地址
+		for _, ip := range ep.ipAddresses() {
+			iptables, rules := portMappingRules("-A", portMapping[0], portMapping[1], ip)
+
+			//判断端口占用
+			iptablesCmd := fmt.Sprintf("-t nat -L PREROUTING -nv")
+			cmd := exec.Command(iptables, strings.Split(iptablesCmd, " ")...)
+			output, err := cmd.Output()
+			if err != nil {
+				log.Errorf("%s 查询失败, %s %s %v", iptables, iptablesCmd, output, err)
+				continue
+			}
+
+			if strings.Index(string(output), fmt.Sprintf("dpt:%s", portMapping[0])) != -1 {
+				log.Errorf("端口 %s 已经被占用", portMapping[0])
+				return fmt.Errorf("端口 %s 已经被占用", portMapping[0])
+			}
+
+			for _, iptablesCmd := range rules {
+				cmd = exec.Command(iptables, strings.Split(iptablesCmd, " ")...)
+				output, err = cmd.Output()
+				if err != nil {
+					log.Errorf("%s 设置失败, %v", iptables, output)
+					continue
+				}
+				log.Infof("端口映射 %s 设置 %s ", pm, iptablesCmd)
+			}
 		}
+	}
+	return nil
+}
 
-		iptablesCmd = fmt.Sprintf("-t nat -A PREROUTING -p tcp -m tcp --dport %s ! -i lo -j DNAT --to-destination %s:%s",
-			portMapping[0], ep.IPAddress.String(), portMapping[1])
-		cmd = exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
-		//err := cmd.Run()
-		output, err = cmd.Output()
-		if err != nil {
-			log.Errorf("iptables 设置失败, %v", output)
+//删除端点的端口映射规则，规则与 configPortMapping 添加时一致
+func cleanPortMapping(ep *Endpoint) {
+	for _, pm := range ep.PortMapping {
+		portMapping := strings.Split(pm, ":")
+		if len(portMapping) != 2 {
 			continue
 		}
-		log.Infof("端口映射 %s 设置 %s ", pm, iptablesCmd)
 
-		//localhost 端口映射
+		for _, ip := range ep.ipAddresses() {
+			iptables, rules := portMappingRules("-D", portMapping[0], portMapping[1], ip)
+			for _, iptablesCmd := range rules {
+				cmd := exec.Command(iptables, strings.Split(iptablesCmd, " ")...)
+				if output, err := cmd.CombinedOutput(); err != nil {
+					log.Warnf("删除端口映射失败 %s %s %v", iptablesCmd, output, err)
+					continue
+				}
+				log.Infof("端口映射 %s 删除 %s ", pm, iptablesCmd)
+			}
+		}
+	}
+}
 
-		iptablesCmd = fmt.Sprintf("-t nat -A OUTPUT -o lo -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
-			portMapping[0], ep.IPAddress.String(), portMapping[1])
-		cmd = exec.Command("iptables", strings.Split(iptablesCmd, " ")...)
-		//err := cmd.Run()
-		output, err = cmd.Output()
-		if err != nil {
-			log.Errorf("iptables localhost 设置失败, %v", output)
-			continue
+//端口映射的 DNAT 规则，action 为 -A 添加或 -D 删除
+//IPv6 地址使用 ip6tables，IPv6 不支持 route_localnet，没有 localhost 端口映射
+func portMappingRules(action, hostPort, containerPort string, ip net.IP) (string, []string) {
+	if ip.To4() == nil {
+		return "ip6tables", []string{
+			fmt.Sprintf("-t nat %s PREROUTING -p tcp -m tcp --dport %s ! -i lo -j DNAT --to-destination [%s]:%s",
+				action, hostPort, ip.String(), containerPort),
 		}
-		log.Infof("端口映射 localhost %s 设置 %s ", pm, iptablesCmd)
 	}
-	return nil
+	return "iptables", []string{
+		fmt.Sprintf("-t nat %s PREROUTING -p tcp -m tcp --dport %s ! -i lo -j DNAT --to-destination %s:%s",
+			action, hostPort, ip.String(), containerPort),
+		//localhost 端口映射
+		fmt.Sprintf("-t nat %s OUTPUT -o lo -p tcp -m tcp --dport %s -j DNAT --to-destination %s:%s",
+			action, hostPort, ip.String(), containerPort),
+	}
 }
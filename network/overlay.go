@@ -0,0 +1,169 @@
+package network
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+const (
+	//VXLAN 网络标识，同一个 overlay 网络在各主机上的 vni 必须一致
+	OptionVni = "vni"
+	//其他主机的地址，逗号分隔
+	OptionPeers = "peers"
+
+	vxlanPort = 4789
+	//VXLAN 封装占用 50 字节
+	vxlanMTU = 1450
+)
+
+//overlay 网络，在 Bridge 上挂载 VXLAN 设备，将多台主机上的同名网络连接为一个二层网络
+//容器端点与 Bridge 网络一致，各主机使用同一个子网，通过 --ip-range 为每台主机指定不重叠的地址范围
+//Bridge 的网关地址与容器地址都从本机的地址范围中分配，容器经本机的 Bridge 访问外部网络
+//network create --driver overlay --subnet 10.10.0.0/24 --ip-range 10.10.0.0/26 -o vni=42 -o peers=10.0.0.2,10.0.0.3 ov
+type OverlayNetworkDriver struct {
+	bridge BridgeNetworkDriver
+}
+
+func (d *OverlayNetworkDriver) Name() string {
+	return "overlay"
+}
+
+func (d *OverlayNetworkDriver) Create(n *Network) error {
+	vni, peers, err := parseOverlayOptions(n.Options)
+	if err != nil {
+		return err
+	}
+
+	if err := d.bridge.Create(n); err != nil {
+		return err
+	}
+
+	if err := createVxlanInterface(getBridgeName(n), vni, peers); err != nil {
+		log.Errorf("创建 overlay 网络 %s 失败 %v", n.Name, err)
+		d.bridge.Delete(*n)
+		return err
+	}
+	return nil
+}
+
+func (d *OverlayNetworkDriver) Delete(network Network) error {
+	vni, _, err := parseOverlayOptions(network.Options)
+	if err == nil {
+		if vxlan, err := netlink.LinkByName(vxlanName(vni)); err == nil {
+			if err := netlink.LinkDel(vxlan); err != nil {
+				return fmt.Errorf("VXLAN %s 删除失败 %v ", vxlanName(vni), err)
+			}
+		}
+	}
+	return d.bridge.Delete(network)
+}
+
+//与 Bridge 网络一致创建 veth，并调低 MTU 留出 VXLAN 封装的空间
+func (d *OverlayNetworkDriver) Connect(network *Network, endpoint *Endpoint) error {
+	if err := d.bridge.Connect(network, endpoint); err != nil {
+		return err
+	}
+	for _, name := range []string{endpoint.Device.Name, endpoint.Device.PeerName} {
+		link, err := netlink.LinkByName(name)
+		if err != nil {
+			return err
+		}
+		if err := netlink.LinkSetMTU(link, vxlanMTU); err != nil {
+			return fmt.Errorf("设置 %s MTU 失败 %v", name, err)
+		}
+	}
+	return nil
+}
+
+func (d *OverlayNetworkDriver) Disconnect(network Network, endpoint *Endpoint) error {
+	return d.bridge.Disconnect(network, endpoint)
+}
+
+//各主机的网关与容器地址需要在本机的 --ip-range 中，否则不同主机会分配到相同的地址
+func checkOverlayRanges(n *Network, gateways map[*net.IPNet]net.IP) error {
+	for _, ipRange := range n.ipRanges() {
+		allocRange := n.allocRangeFor(ipRange)
+		if allocRange == nil {
+			return fmt.Errorf("overlay 网络需要使用 --ip-range 为每台主机指定不重叠的地址范围，网段 %s 未指定", ipRange.String())
+		}
+		if gateway := gateways[ipRange]; gateway != nil && !allocRange.Contains(gateway) {
+			return fmt.Errorf("overlay 网络的网关 %s 需要在本机的地址范围 %s 中", gateway.String(), allocRange.String())
+		}
+	}
+	return nil
+}
+
+//解析 -o vni=42 -o peers=10.0.0.2,10.0.0.3
+func parseOverlayOptions(options map[string]string) (int, []net.IP, error) {
+	vni, err := strconv.Atoi(options[OptionVni])
+	if err != nil || vni <= 0 || vni >= 1<<24 {
+		return 0, nil, fmt.Errorf("错误的 -o %s=%s，取值范围 1-16777215", OptionVni, options[OptionVni])
+	}
+
+	var peers []net.IP
+	if options[OptionPeers] != "" {
+		for _, peer := range strings.Split(options[OptionPeers], ",") {
+			ip := net.ParseIP(strings.TrimSpace(peer))
+			if ip == nil {
+				return 0, nil, fmt.Errorf("错误的 -o %s 地址 %s", OptionPeers, peer)
+			}
+			peers = append(peers, ip)
+		}
+	}
+	return vni, peers, nil
+}
+
+func vxlanName(vni int) string {
+	return fmt.Sprintf("vxlan%d", vni)
+}
+
+//创建 VXLAN 设备挂载到 Bridge 上，并为每个主机添加全零 MAC 的转发表项
+//广播与未知单播报文复制发送到所有主机，容器的 MAC 地址通过学习获得
+func createVxlanInterface(bridgeName string, vni int, peers []net.IP) error {
+	br, err := netlink.LinkByName(bridgeName)
+	if err != nil {
+		return err
+	}
+
+	la := netlink.NewLinkAttrs()
+	la.Name = vxlanName(vni)
+	la.MasterIndex = br.Attrs().Index
+	la.MTU = vxlanMTU
+	vxlan := &netlink.Vxlan{
+		LinkAttrs: la,
+		VxlanId:   vni,
+		Port:      vxlanPort,
+		Learning:  true,
+	}
+	if err := netlink.LinkAdd(vxlan); err != nil {
+		return fmt.Errorf("创建 VXLAN %s 失败 %v", la.Name, err)
+	}
+
+	for _, peer := range peers {
+		//bridge fdb append 00:00:00:00:00:00 dev vxlan42 dst 10.0.0.2
+		fdb := &netlink.Neigh{
+			LinkIndex:    vxlan.Attrs().Index,
+			Family:       unix.AF_BRIDGE,
+			State:        netlink.NUD_PERMANENT,
+			Flags:        netlink.NTF_SELF,
+			IP:           peer,
+			HardwareAddr: make(net.HardwareAddr, 6),
+		}
+		if err := netlink.NeighAppend(fdb); err != nil {
+			netlink.LinkDel(vxlan)
+			return fmt.Errorf("添加 VXLAN 主机 %s 失败 %v", peer, err)
+		}
+	}
+
+	if err := netlink.LinkSetUp(vxlan); err != nil {
+		netlink.LinkDel(vxlan)
+		return fmt.Errorf("启动 VXLAN %s 失败 %v", la.Name, err)
+	}
+	return nil
+}
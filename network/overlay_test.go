@@ -0,0 +1,190 @@
+package network
+
+import (
+	"net"
+	"os/exec"
+	"path"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+)
+
+func TestCheckOverlayRanges(t *testing.T) {
+	tests := []struct {
+		name     string
+		subnets  []string
+		ipRanges []string
+		gateway  string
+		wantErr  bool
+	}{
+		{"ip range", []string{"10.10.0.0/24"}, []string{"10.10.0.64/26"}, "", false},
+		{"gateway in ip range", []string{"10.10.0.0/24"}, []string{"10.10.0.64/26"}, "10.10.0.65", false},
+		{"missing ip range", []string{"10.10.0.0/24"}, nil, "", true},
+		{"gateway out of ip range", []string{"10.10.0.0/24"}, []string{"10.10.0.64/26"}, "10.10.0.1", true},
+		{"dual stack", []string{"10.10.0.0/24", "fd00::/64"}, []string{"10.10.0.64/26", "fd00::1:0/112"}, "", false},
+		{"dual stack missing ipv6 range", []string{"10.10.0.0/24", "fd00::/64"}, []string{"10.10.0.64/26"}, "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ipRange, ipv6Range, err := parseSubnets(tt.subnets, len(tt.subnets) > 1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			nw := &Network{Name: "ov", Driver: "overlay", IpRange: ipRange, IPv6Range: ipv6Range}
+			if err := parseIPRanges(nw, tt.ipRanges); err != nil {
+				t.Fatal(err)
+			}
+			var gateways []string
+			if tt.gateway != "" {
+				gateways = append(gateways, tt.gateway)
+			}
+			parsed, err := parseGateways(nw, gateways)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := checkOverlayRanges(nw, parsed); (err != nil) != tt.wantErr {
+				t.Errorf("checkOverlayRanges() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+//在指定的 network namespace 中执行 fn
+func runInTestNetns(t *testing.T, ns netns.NsHandle, fn func()) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer origin.Close()
+	if err := netns.Set(ns); err != nil {
+		t.Fatal(err)
+	}
+	defer netns.Set(origin)
+	fn()
+}
+
+//两个 network namespace 模拟两台主机，通过 veth 连接
+func connectTestHosts(t *testing.T, hostA, hostB netns.NsHandle, addrA, addrB string) {
+	runInTestNetns(t, hostA, func() {
+		veth := &netlink.Veth{LinkAttrs: netlink.LinkAttrs{Name: "underlay0"}, PeerName: "underlay1"}
+		if err := netlink.LinkAdd(veth); err != nil {
+			t.Fatal(err)
+		}
+		peer, err := netlink.LinkByName("underlay1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := netlink.LinkSetNsFd(peer, int(hostB)); err != nil {
+			t.Fatal(err)
+		}
+	})
+	for _, host := range []struct {
+		ns   netns.NsHandle
+		name string
+		addr string
+	}{{hostA, "underlay0", addrA}, {hostB, "underlay1", addrB}} {
+		runInTestNetns(t, host.ns, func() {
+			if err := setInterfaceIP(host.name, host.addr); err != nil {
+				t.Fatal(err)
+			}
+			if err := setInterfaceUP(host.name); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+//两台主机创建同一个 overlay 网络，网关与容器地址从各自的 --ip-range 中分配，Bridge 之间通过 VXLAN 互通
+func TestOverlayTwoHosts(t *testing.T) {
+	hostA, hostB := setupTestNetns(t), setupTestNetns(t)
+	if _, err := exec.LookPath("iptables"); err != nil {
+		t.Skip("需要 iptables")
+	}
+	runInTestNetns(t, hostA, func() {
+		probe := &netlink.Vxlan{LinkAttrs: netlink.LinkAttrs{Name: "vxlan-probe"}, VxlanId: 1, Port: vxlanPort}
+		if err := netlink.LinkAdd(probe); err != nil {
+			t.Skipf("内核不支持 VXLAN %v", err)
+		}
+		netlink.LinkDel(probe)
+	})
+	connectTestHosts(t, hostA, hostB, "192.168.200.1/24", "192.168.200.2/24")
+
+	allocator := ipAllocator
+	t.Cleanup(func() { ipAllocator = allocator })
+
+	hosts := []struct {
+		ns          netns.NsHandle
+		peer        string
+		ipRange     string
+		wantGateway string
+	}{
+		{hostA, "192.168.200.2", "10.10.0.0/26", "10.10.0.1"},
+		{hostB, "192.168.200.1", "10.10.0.64/26", "10.10.0.64"},
+	}
+	gateways := make([]net.IP, 0, len(hosts))
+	for _, host := range hosts {
+		//每台主机有独立的分配信息
+		ipAllocator = &IPAM{SubnetAllocatorPath: path.Join(t.TempDir(), "subnet.json")}
+		ipRange, _, _ := parseSubnets([]string{"10.10.0.0/24"}, false)
+		nw := &Network{
+			Name:    "ov",
+			Driver:  "overlay",
+			IpRange: ipRange,
+			Options: map[string]string{OptionVni: "42", OptionPeers: host.peer},
+		}
+		if err := parseIPRanges(nw, []string{host.ipRange}); err != nil {
+			t.Fatal(err)
+		}
+		if err := checkOverlayRanges(nw, nil); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := allocateGateways(nw, nil); err != nil {
+			t.Fatal(err)
+		}
+		if !nw.IpRange.IP.Equal(net.ParseIP(host.wantGateway)) {
+			t.Errorf("allocateGateways() got = %v, want %v", nw.IpRange.IP, host.wantGateway)
+		}
+		ips, err := allocateIPs(nw, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !nw.AllocRange.Contains(ips[0]) {
+			t.Errorf("allocateIPs() got = %v, want in %v", ips[0], nw.AllocRange)
+		}
+
+		runInTestNetns(t, host.ns, func() {
+			d := &OverlayNetworkDriver{}
+			if err := d.Create(nw); err != nil {
+				t.Fatalf("Create() error = %v", err)
+			}
+		})
+		gateways = append(gateways, nw.IpRange.IP)
+	}
+
+	//主机 A 通过 overlay 网络访问主机 B 的 Bridge 地址
+	var listener net.Listener
+	runInTestNetns(t, hostB, func() {
+		var err error
+		if listener, err = net.Listen("tcp", net.JoinHostPort(gateways[1].String(), "0")); err != nil {
+			t.Fatal(err)
+		}
+	})
+	defer listener.Close()
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			conn.Close()
+		}
+	}()
+	runInTestNetns(t, hostA, func() {
+		conn, err := net.DialTimeout("tcp", listener.Addr().String(), 5*time.Second)
+		if err != nil {
+			t.Fatalf("主机 A 访问 %s 失败 %v", listener.Addr(), err)
+		}
+		conn.Close()
+	})
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RedDragonet/rocker/cgroup"
+	"github.com/RedDragonet/rocker/container"
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+)
+
+//通过 freezer 冻结容器内的所有进程
+func PauseContainer(containerName string) error {
+	info, err := container.GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if !info.State.Running {
+		return fmt.Errorf("容器 %s 未运行", info.Name)
+	}
+	if info.State.Restarting {
+		return fmt.Errorf("容器 %s 正在等待重启", info.Name)
+	}
+	if info.State.Paused {
+		return fmt.Errorf("容器 %s 已暂停", info.Name)
+	}
+
+	if err := cgroup.NewCgroupManager(info.ID).Freeze(); err != nil {
+		log.Errorf("PauseContainer %s 失败 %v", info.Name, err)
+		return fmt.Errorf("暂停容器 %s 失败 %v", info.Name, err)
+	}
+	return container.RecordContainerPaused(info.ID, true)
+}
+
+//恢复被冻结的容器
+func UnpauseContainer(containerName string) error {
+	info, err := container.GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if !info.State.Paused {
+		return fmt.Errorf("容器 %s 未暂停", info.Name)
+	}
+
+	if err := cgroup.NewCgroupManager(info.ID).Thaw(); err != nil {
+		log.Errorf("UnpauseContainer %s 失败 %v", info.Name, err)
+		return fmt.Errorf("恢复容器 %s 失败 %v", info.Name, err)
+	}
+	return container.RecordContainerPaused(info.ID, false)
+}
@@ -0,0 +1,36 @@
+package signal
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestParseSignal(t *testing.T) {
+	tests := []struct {
+		name    string
+		signal  string
+		want    syscall.Signal
+		wantErr bool
+	}{
+		{"full name", "SIGTERM", syscall.SIGTERM, false},
+		{"short name", "KILL", syscall.SIGKILL, false},
+		{"lower case", "sighup", syscall.SIGHUP, false},
+		{"number", "10", syscall.SIGUSR1, false},
+		{"zero", "0", -1, true},
+		{"out of range", "65", -1, true},
+		{"unknown", "SIGFOO", -1, true},
+		{"empty", "", -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseSignal(tt.signal)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseSignal() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseSignal() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
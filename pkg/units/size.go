@@ -0,0 +1,55 @@
+package units
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	KiB = 1024
+	MiB = 1024 * KiB
+	GiB = 1024 * MiB
+	TiB = 1024 * GiB
+)
+
+var binaryAbbrs = []string{"B", "KiB", "MiB", "GiB", "TiB"}
+
+var sizeRegex = regexp.MustCompile(`^(\d+(\.\d+)?)\s*([kKmMgGtT]?)(i?[bB])?$`)
+
+var unitMap = map[string]int64{
+	"":  1,
+	"k": KiB,
+	"m": MiB,
+	"g": GiB,
+	"t": TiB,
+}
+
+//解析可读的容量字符串为字节数，单位按 1024 换算
+//如 512m、1.5g、100kb、64MiB、1024
+//参考 https://github.com/docker/go-units/blob/master/size.go
+func RAMInBytes(size string) (int64, error) {
+	matches := sizeRegex.FindStringSubmatch(strings.TrimSpace(size))
+	if len(matches) != 5 {
+		return -1, fmt.Errorf("无效的容量格式: '%s'", size)
+	}
+
+	value, err := strconv.ParseFloat(matches[1], 64)
+	if err != nil {
+		return -1, fmt.Errorf("无效的容量格式: '%s'", size)
+	}
+
+	unit := unitMap[strings.ToLower(matches[3])]
+	return int64(value * float64(unit)), nil
+}
+
+//将字节数格式化为可读的容量，如 1.5MiB
+func BytesSize(size float64) string {
+	i := 0
+	for size >= 1024 && i < len(binaryAbbrs)-1 {
+		size /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.4g%s", size, binaryAbbrs[i])
+}
@@ -0,0 +1,61 @@
+package units
+
+import "testing"
+
+func TestRAMInBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		size    string
+		want    int64
+		wantErr bool
+	}{
+		{"bytes", "1024", 1024, false},
+		{"b", "32b", 32, false},
+		{"k", "4k", 4 * KiB, false},
+		{"kb", "4kb", 4 * KiB, false},
+		{"m", "512m", 512 * MiB, false},
+		{"M", "512M", 512 * MiB, false},
+		{"MiB", "64MiB", 64 * MiB, false},
+		{"g", "1g", GiB, false},
+		{"fraction", "1.5g", GiB + 512*MiB, false},
+		{"t", "2t", 2 * TiB, false},
+		{"empty", "", -1, true},
+		{"negative", "-1m", -1, true},
+		{"unknown unit", "10x", -1, true},
+		{"no number", "m", -1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := RAMInBytes(tt.size)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("RAMInBytes() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("RAMInBytes() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBytesSize(t *testing.T) {
+	tests := []struct {
+		name string
+		size float64
+		want string
+	}{
+		{"bytes", 512, "512B"},
+		{"KiB", 1024, "1KiB"},
+		{"MiB", 1.5 * MiB, "1.5MiB"},
+		{"GiB", 2 * GiB, "2GiB"},
+		{"TiB", 3 * TiB, "3TiB"},
+		{"overflow", 2048 * TiB, "2048TiB"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := BytesSize(tt.size); got != tt.want {
+				t.Errorf("BytesSize() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
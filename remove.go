@@ -7,9 +7,26 @@ import (
 )
 
 func RemoveContainer(containerName string) {
-	err := container.RemoveContainer(containerName)
+	info, err := container.GetContainerInfo(containerName)
 	if err != nil {
 		log.Errorf("RemoveContainer %s error %v", containerName, err)
 		return
 	}
+	err = container.RemoveContainer(info.ID)
+	if err != nil {
+		log.Errorf("RemoveContainer %s error %v", containerName, err)
+		return
+	}
+	//container 包不能引用 network 包，IP地址在这里释放
+	releaseNetwork(info)
+}
+
+//清理容器并释放容器的IP地址
+func cleanUpContainer(containerID string) {
+	info, err := container.GetContainerInfo(containerID)
+	if err != nil {
+		return
+	}
+	container.CleanUp(info.ID, info.Config.Volumes)
+	releaseNetwork(info)
 }
@@ -1,114 +1,232 @@
 package main
 
 import (
-	"github.com/RedDragonet/rocker/image"
-	"github.com/RedDragonet/rocker/network"
+	"fmt"
 	"os"
 	"os/exec"
+	"runtime"
 	"strings"
+	"syscall"
 
 	"github.com/RedDragonet/rocker/cgroup"
-	"github.com/RedDragonet/rocker/cgroup/subsystem"
 	"github.com/RedDragonet/rocker/container"
+	"github.com/RedDragonet/rocker/image"
+	"github.com/RedDragonet/rocker/network"
 	log "github.com/RedDragonet/rocker/pkg/pidlog"
 	"github.com/RedDragonet/rocker/pkg/stringid"
+	"github.com/vishvananda/netns"
 )
 
 const DEFAULT_BRIDGE = "rocker0"
 
-func Run(interactive, tty bool, net string, volumes, portMapping, environ, argv []string, res *subsystem.ResourceConfig, containerName string) {
-	containerID := stringid.GenerateRandomID()
-	var retErr error
+func Run(interactive, tty bool, containerName string, config container.Config) {
+	//monitor 进程使用前台进程生成的容器ID
+	containerID := monitorContainerID()
+	if containerID == "" {
+		containerID = stringid.GenerateRandomID()
+	}
 
-	defer func() {
-		if retErr != nil {
-			log.Errorf("启动失败 %v", retErr)
-			container.StopContainer(containerID)
-			container.RemoveContainer(containerID)
-		}
-	}()
+	info, err := createContainer(containerID, containerName, config)
+	if err != nil {
+		log.Errorf("创建容器失败 %v", err)
+		return
+	}
+
+	parent, err := startContainer(info, interactive, tty)
+	if err != nil {
+		log.Errorf("启动失败 %v", err)
+		cleanUpContainer(containerID)
+		return
+	}
 
-	if containerName == "" {
-		containerName = containerID[:12]
+	log.Infof("创建父运行成功，开始等待")
+	log.Infof("当前进程ID %d ", os.Getpid())
+
+	//交互模式
+	//父进程等待子进程退出
+	if interactive {
+		exitCode := waitContainer(parent, info)
+		autoRemoveContainer(containerID)
+		log.Infof("父进程运行结束")
+		os.Exit(exitCode)
 	}
 
+	//后台运行，通知前台进程后由 monitor 等待容器退出，并按重启策略重启
+	notifyMonitorReady(containerID)
+	superviseContainer(parent, info)
+	autoRemoveContainer(containerID)
+
+	log.Infof("父进程运行结束")
+
+	os.Exit(0)
+}
+
+//根据容器配置启动容器进程，设置 cgroup 与网络，run 与 start 共用
+//失败时结束容器进程并卸载容器目录，保留容器层
+func startContainer(info *container.ContainerInfo, interactive, tty bool) (*exec.Cmd, error) {
 	image.Init()
-	parent, pipeWrite := container.NewParentProcess(interactive, tty, argv[0], volumes, environ, containerID, containerName)
+	parent, pipeWrite := container.NewParentProcess(interactive, tty, info.Config.Image, info.Config.Volumes, info.Config.Env, info.ID, info.Name)
 	if parent == nil {
 		log.Errorf("创建父进程失败")
-		return
+		container.UnmountContainer(info.ID, info.Config.Volumes)
+		return nil, fmt.Errorf("创建父进程失败")
 	}
 
 	log.Infof("当前进程ID %d ", os.Getpid())
 
-	if err := parent.Start(); err != nil {
-		log.Infof("父进程运行失败")
+	//host 与 container:<name> 模式不创建新的 network namespace
+	networkMode := info.Config.NetworkMode
+	if !networkMode.IsPrivate() {
+		parent.SysProcAttr.Cloneflags &^= syscall.CLONE_NEWNET
 	}
 
-	container.RecordContainerInfo(parent.Process.Pid, argv, containerName, containerID, volumes, portMapping, res)
+	if err := startParentProcess(parent, networkMode); err != nil {
+		log.Errorf("父进程运行失败 %v", err)
+		pipeWrite.Close()
+		container.UnmountContainer(info.ID, info.Config.Volumes)
+		return nil, fmt.Errorf("父进程运行失败 %v", err)
+	}
 
-	//cgroup初始化
-	cgroupManager := cgroup.NewCgroupManager(containerID)
-	defer cgroupManager.Destroy()
-	err := cgroupManager.Set(res)
+	var retErr error
+	cgroupManager := cgroup.NewCgroupManager(info.ID)
+	defer func() {
+		if retErr != nil {
+			pipeWrite.Close()
+			parent.Process.Kill()
+			parent.Wait()
+			cgroupManager.Destroy()
+			container.UnmountContainer(info.ID, info.Config.Volumes)
+			container.RecordContainerExit(info.ID, getExitCode(parent.ProcessState), false)
+		}
+	}()
+
+	started, err := container.RecordContainerStart(info.ID, parent.Process.Pid)
 	if err != nil {
 		retErr = err
-		return
+		return nil, retErr
 	}
 
-	err = cgroupManager.Apply(parent.Process.Pid, res)
-	if err != nil {
+	//cgroup初始化
+	res := started.Config.CGroup.ResourceConfig()
+	if err := cgroupManager.Set(res); err != nil {
 		retErr = err
-		return
+		return nil, retErr
 	}
 
-	//创建默认设备
-	if len(portMapping) > 0 && net == "" {
-		net = DEFAULT_BRIDGE
-		createDefaultBridge()
+	if err := cgroupManager.Apply(parent.Process.Pid, res); err != nil {
+		retErr = err
+		return nil, retErr
 	}
 
-	if net != "" {
-		// 配置网络
-		if err := network.Init(); err != nil {
+	if networkMode.IsNone() {
+		if err := network.SetupLoopback(started); err != nil {
 			retErr = err
-			return
-		}
-		containerInfo := &container.ContainerInfo{
-			ID: containerID,
-			State: container.State{
-				Pid: parent.Process.Pid,
-			},
-			Name: containerName,
-			Config: container.Config{
-				PortMapping: portMapping,
-			},
+			return nil, retErr
 		}
-		if err := network.Connect(net, containerInfo); err != nil {
-			log.Errorf("Error Connect Network %v", err)
+	} else if len(started.Config.Networks) > 0 {
+		if err := connectNetworks(started); err != nil {
 			retErr = err
-			return
+			return nil, retErr
 		}
 	}
 
-	if err := sendInitCommand(argv[1:], pipeWrite); err != nil {
+	if err := sendInitCommand(started.Config.Cmd[1:], pipeWrite); err != nil {
 		retErr = err
-		return
+		return nil, retErr
 	}
+	return parent, nil
+}
 
-	log.Infof("创建父运行成功，开始等待")
-	log.Infof("当前进程ID %d ", os.Getpid())
+//启动容器进程，container:<name> 模式下在加入的容器的 network namespace 中启动
+//容器进程在新的 user namespace 中，没有权限 setns 到其他容器的 network namespace，
+//因此由父进程通过 /proc/<pid>/ns/net 加入后再创建容器进程，子进程继承当前线程的 network namespace
+func startParentProcess(parent *exec.Cmd, networkMode container.NetworkMode) error {
+	if !networkMode.IsContainer() {
+		return parent.Start()
+	}
 
-	//交互模式
-	//父进程等待子进程退出
-	if interactive {
-		_ = parent.Wait()
-		container.CleanUp(containerID, volumes)
+	target, err := container.GetContainerInfo(networkMode.ConnectedContainer())
+	if err != nil {
+		return err
+	}
+	if !target.State.Running || target.State.Pid == 0 {
+		return fmt.Errorf("网络模式 %s 容器 %s 未运行", networkMode, target.Name)
 	}
 
-	log.Infof("父进程运行结束")
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
 
-	os.Exit(0)
+	origns, err := netns.Get()
+	if err != nil {
+		return fmt.Errorf("获取当前的 network namespace 失败 %v", err)
+	}
+	defer origns.Close()
+
+	targetns, err := netns.GetFromPid(target.State.Pid)
+	if err != nil {
+		return fmt.Errorf("获取容器 %s 的 network namespace 失败 %v", target.Name, err)
+	}
+	defer targetns.Close()
+
+	if err := netns.Set(targetns); err != nil {
+		return fmt.Errorf("加入容器 %s 的 network namespace 失败 %v", target.Name, err)
+	}
+	defer netns.Set(origns)
+
+	return parent.Start()
+}
+
+//将容器连接到配置的所有网络，第一个网络为主网络
+//再次启动时先释放上一次分配的IP地址与端口映射
+func connectNetworks(info *container.ContainerInfo) error {
+	//创建默认设备
+	if hasNetwork(info.Config.Networks, DEFAULT_BRIDGE) {
+		createDefaultBridge()
+	}
+
+	// 配置网络
+	if err := network.Init(); err != nil {
+		return err
+	}
+
+	for len(info.Endpoints) > 0 {
+		ep := info.Endpoints[0]
+		if err := network.Disconnect(ep.Network, info); err != nil {
+			log.Warnf("释放 ip 地址 %s 失败 %v", ep.IPAddress.String(), err)
+			info.Endpoints = info.Endpoints[1:]
+		}
+	}
+
+	for i, networkName := range info.Config.Networks {
+		if err := network.Connect(networkName, info, i == 0); err != nil {
+			log.Errorf("Error Connect Network %v", err)
+			return err
+		}
+	}
+	return nil
+}
+
+//删除容器时释放容器的网络端点与IP地址
+func releaseNetwork(info *container.ContainerInfo) {
+	if len(info.Endpoints) == 0 && info.Config.LegacyIP == nil {
+		return
+	}
+	if err := network.Init(); err != nil {
+		log.Warnf("释放容器 %s 网络失败 %v", info.Name, err)
+		return
+	}
+	for i := range info.Endpoints {
+		ep := &info.Endpoints[i]
+		if err := network.ReleaseEndpoint(ep); err != nil {
+			log.Warnf("释放 ip 地址 %s 失败 %v", ep.IPAddress.String(), err)
+		}
+	}
+	//旧版本创建的容器只记录了IP地址
+	if info.Config.LegacyIP != nil {
+		if err := network.ReleaseLegacyEndpoint(info); err != nil {
+			log.Warnf("释放 ip 地址 %s 失败 %v", info.Config.LegacyIP.String(), err)
+		}
+	}
 }
 
 func createDefaultBridge() {
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RedDragonet/rocker/container"
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+)
+
+//启动已停止的容器，重新挂载容器层并按记录的配置创建 namespace、cgroup 与网络
+//与 run -d 一致，由 monitor 进程启动并等待容器退出
+func StartContainer(containerName string) error {
+	info, err := container.GetContainerInfo(containerName)
+	if err != nil {
+		return err
+	}
+	if info.State.Running {
+		return fmt.Errorf("容器 %s 正在运行", info.Name)
+	}
+
+	if !isMonitor() {
+		if _, err := startMonitor(info.ID, []string{"start", info.ID}); err != nil {
+			return err
+		}
+		fmt.Println(containerName)
+		return nil
+	}
+
+	monitorContainerID()
+	if err := container.ResetContainerRestartCount(info.ID); err != nil {
+		return err
+	}
+	parent, err := startContainer(info, false, false)
+	if err != nil {
+		log.Errorf("StartContainer %s 失败 %v", info.Name, err)
+		return err
+	}
+
+	notifyMonitorReady(info.ID)
+	superviseContainer(parent, info)
+	autoRemoveContainer(info.ID)
+	return nil
+}
+
+//停止并重新启动容器
+func RestartContainer(containerName string, timeout int) error {
+	if err := StopContainer(containerName, timeout, ""); err != nil {
+		return err
+	}
+	return StartContainer(containerName)
+}
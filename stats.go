@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/RedDragonet/rocker/cgroup"
+	"github.com/RedDragonet/rocker/cgroup/subsystem"
+	"github.com/RedDragonet/rocker/container"
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"github.com/RedDragonet/rocker/pkg/units"
+)
+
+//一次采样的容器资源使用情况
+type containerStats struct {
+	info  *container.ContainerInfo
+	stats *subsystem.Stats
+	netRx uint64
+	netTx uint64
+	//容器使用宿主机的 network namespace，网卡统计为宿主机的数据
+	hostNet bool
+	read    time.Time
+	err     error
+}
+
+//显示容器的资源使用情况，未指定容器时显示所有运行中的容器
+func StatsContainers(containerNames []string, noStream bool) error {
+	previous := map[string]*containerStats{}
+	for {
+		containers, err := getStatsContainers(containerNames)
+		if err != nil {
+			return err
+		}
+
+		//CPU 使用率需要两次采样计算
+		if len(previous) == 0 {
+			for _, info := range containers {
+				previous[info.ID] = collectStats(info)
+			}
+			time.Sleep(time.Second)
+		}
+
+		current := map[string]*containerStats{}
+		for _, info := range containers {
+			current[info.ID] = collectStats(info)
+		}
+
+		if !noStream {
+			//清屏并移动光标到左上角
+			fmt.Print("\033[2J\033[H")
+		}
+		printStats(containers, previous, current)
+
+		if noStream {
+			return nil
+		}
+		previous = current
+		time.Sleep(time.Second)
+	}
+}
+
+func getStatsContainers(containerNames []string) ([]*container.ContainerInfo, error) {
+	var containers []*container.ContainerInfo
+	if len(containerNames) > 0 {
+		for _, containerName := range containerNames {
+			info, err := container.GetContainerInfo(containerName)
+			if err != nil {
+				return nil, err
+			}
+			containers = append(containers, info)
+		}
+		return containers, nil
+	}
+
+	all, err := getAllContainers()
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range all {
+		if info.State.Running {
+			containers = append(containers, info)
+		}
+	}
+	return containers, nil
+}
+
+func collectStats(info *container.ContainerInfo) *containerStats {
+	s := &containerStats{
+		info: info,
+		read: time.Now(),
+	}
+	if !info.State.Running {
+		s.err = fmt.Errorf("容器 %s 未运行", info.Name)
+		return s
+	}
+
+	s.stats, s.err = cgroup.NewCgroupManager(info.ID).GetStats()
+	if s.err != nil {
+		log.Debugf("获取容器 %s cgroup 统计失败 %v", info.Name, s.err)
+		return s
+	}
+
+	if s.hostNet = sharesHostNetns(info.State.Pid); s.hostNet {
+		return s
+	}
+	s.netRx, s.netTx, s.err = getNetworkStats(info.State.Pid)
+	if s.err != nil {
+		log.Debugf("获取容器 %s 网络统计失败 %v", info.Name, s.err)
+	}
+	return s
+}
+
+func printStats(containers []*container.ContainerInfo, previous, current map[string]*containerStats) {
+	memTotal := hostMemTotal()
+
+	w := tabwriter.NewWriter(os.Stdout, 12, 1, 3, ' ', 0)
+	fmt.Fprint(w, "CONTAINER ID\tNAME\tCPU %\tMEM USAGE / LIMIT\tMEM %\tNET I/O\tBLOCK I/O\tPIDS\n")
+	for _, info := range containers {
+		cur := current[info.ID]
+		if cur.err != nil {
+			fmt.Fprintf(w, "%s\t%s\t--\t-- / --\t--\t--\t--\t--\n", info.ID[:12], info.Name)
+			continue
+		}
+
+		cpuPercent := 0.0
+		if prev, ok := previous[info.ID]; ok && prev.err == nil {
+			cpuDelta := float64(cur.stats.CpuUsage) - float64(prev.stats.CpuUsage)
+			timeDelta := float64(cur.read.Sub(prev.read).Nanoseconds())
+			if cpuDelta > 0 && timeDelta > 0 {
+				cpuPercent = cpuDelta / timeDelta * 100
+			}
+		}
+
+		//未限制内存时显示宿主机内存
+		memLimit := cur.stats.MemoryLimit
+		if memLimit == 0 || (memTotal > 0 && memLimit > memTotal) {
+			memLimit = memTotal
+		}
+		memPercent := 0.0
+		if memLimit > 0 {
+			memPercent = float64(cur.stats.MemoryUsage) / float64(memLimit) * 100
+		}
+
+		netIO := units.BytesSize(float64(cur.netRx)) + " / " + units.BytesSize(float64(cur.netTx))
+		if cur.hostNet {
+			netIO = "host"
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s / %s\t%.2f%%\t%s\t%s / %s\t%d\n",
+			info.ID[:12],
+			info.Name,
+			cpuPercent,
+			units.BytesSize(float64(cur.stats.MemoryUsage)),
+			units.BytesSize(float64(memLimit)),
+			memPercent,
+			netIO,
+			units.BytesSize(float64(cur.stats.BlkioRead)),
+			units.BytesSize(float64(cur.stats.BlkioWrite)),
+			cur.stats.PidsCurrent)
+	}
+	if err := w.Flush(); err != nil {
+		log.Errorf("Flush error %v", err)
+		return
+	}
+}
+
+//容器是否与宿主机共用 network namespace，包括 --net host 以及加入了 host 模式容器的 --net container:<name>
+func sharesHostNetns(pid int) bool {
+	containerNetns, err := os.Readlink(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil {
+		return false
+	}
+	//rocker 命令运行在宿主机的 network namespace 中
+	hostNetns, err := os.Readlink("/proc/self/ns/net")
+	return err == nil && containerNetns == hostNetns
+}
+
+//读取容器 network namespace 中所有网卡（不含 lo）的收发字节数
+func getNetworkStats(pid int) (rx uint64, tx uint64, err error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/net/dev", pid))
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	/*
+		Inter-|   Receive                                                |  Transmit
+		 face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+		  eth0:    1296      16    0    0    0     0          0         0     1296      16    0    0    0     0       0          0
+	*/
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		idx := strings.Index(line, ":")
+		if idx < 0 {
+			continue
+		}
+		if strings.TrimSpace(line[:idx]) == "lo" {
+			continue
+		}
+		fields := strings.Fields(line[idx+1:])
+		if len(fields) < 9 {
+			continue
+		}
+		r, _ := strconv.ParseUint(fields[0], 10, 64)
+		t, _ := strconv.ParseUint(fields[8], 10, 64)
+		rx += r
+		tx += t
+	}
+	return rx, tx, scanner.Err()
+}
+
+//宿主机总内存，单位字节
+func hostMemTotal() uint64 {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	//MemTotal:       16314668 kB
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) >= 2 && fields[0] == "MemTotal:" {
+			total, _ := strconv.ParseUint(fields[1], 10, 64)
+			return total * units.KiB
+		}
+	}
+	return 0
+}
@@ -1,15 +1,29 @@
 package main
 
 import (
+	"syscall"
+	"time"
+
 	"github.com/RedDragonet/rocker/container"
 	_ "github.com/RedDragonet/rocker/nsenter"
 	log "github.com/RedDragonet/rocker/pkg/pidlog"
+	"github.com/RedDragonet/rocker/pkg/signal"
 )
 
-func StopContainer(containerName string) {
-	err := container.StopContainer(containerName)
+func StopContainer(containerName string, timeout int, stopSignal string) error {
+	var sig syscall.Signal
+	if stopSignal != "" {
+		s, err := signal.ParseSignal(stopSignal)
+		if err != nil {
+			return err
+		}
+		sig = s
+	}
+
+	err := container.StopContainer(containerName, time.Duration(timeout)*time.Second, sig)
 	if err != nil {
 		log.Errorf("StopContainer %s error %v", containerName, err)
-		return
+		return err
 	}
+	return nil
 }
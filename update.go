@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RedDragonet/rocker/cgroup"
+	"github.com/RedDragonet/rocker/cgroup/subsystem"
+	"github.com/RedDragonet/rocker/container"
+	log "github.com/RedDragonet/rocker/pkg/pidlog"
+)
+
+//更新容器的资源限制，容器未运行时只更新配置，下次启动时生效
+func UpdateContainer(info *container.ContainerInfo, res *subsystem.ResourceConfig) error {
+	if err := res.Validate(); err != nil {
+		return err
+	}
+
+	if info.State.Running {
+		if err := checkMemoryUsage(info.ID, res); err != nil {
+			return err
+		}
+
+		cgroupManager := cgroup.NewCgroupManager(info.ID)
+		if err := cgroupManager.Set(res); err != nil {
+			log.Errorf("UpdateContainer %s 设置 cgroup 失败 %v", info.Name, err)
+			return fmt.Errorf("更新容器 %s 资源限制失败 %v", info.Name, err)
+		}
+	}
+
+	if err := container.RecordContainerResource(info.ID, res); err != nil {
+		return fmt.Errorf("更新容器 %s 配置失败 %v", info.Name, err)
+	}
+	log.Infof("UpdateContainer %s 成功", info.Name)
+	return nil
+}
+
+//内存上限小于当前使用量时，内核会拒绝设置
+func checkMemoryUsage(containerID string, res *subsystem.ResourceConfig) error {
+	if res.MemoryLimit == "" {
+		return nil
+	}
+	limit, err := subsystem.ParseMemory(res.MemoryLimit)
+	if err != nil || limit <= 0 {
+		return err
+	}
+
+	usage, err := subsystem.GetMemoryUsage(containerID)
+	if err != nil {
+		log.Warnf("获取容器 %s 内存使用量失败 %v", containerID, err)
+		return nil
+	}
+	if limit < usage {
+		return fmt.Errorf("内存上限 %s 小于容器当前的内存使用量 %d", res.MemoryLimit, usage)
+	}
+	return nil
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RedDragonet/rocker/container"
+)
+
+//依次等待容器退出并打印退出码，返回最后一个容器的退出码
+func WaitContainers(containerNames []string) (int, error) {
+	exitCode := 0
+	for _, containerName := range containerNames {
+		code, err := container.WaitContainer(containerName)
+		if err != nil {
+			return -1, err
+		}
+		fmt.Println(code)
+		exitCode = code
+	}
+	return exitCode, nil
+}